@@ -0,0 +1,191 @@
+/*
+Copyright Red Hat.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InsightsProxySpec defines the desired state of InsightsProxy.
+// Any field left unset falls back to the default provided by the
+// operator's environment variables.
+type InsightsProxySpec struct {
+	// Whether the Insights proxy should be deployed.
+	// Defaults to the value of the INSIGHTS_ENABLED environment variable.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+	// The Red Hat Insights server host where reports will be forwarded (e.g. console.redhat.com).
+	// Defaults to the value of the INSIGHTS_BACKEND_DOMAIN environment variable.
+	// +optional
+	BackendDomain string `json:"backendDomain,omitempty"`
+	// The URL of an HTTP or HTTPS proxy used to reach the Insights backend
+	// (e.g. https://proxy.example.com:3128). A value without a scheme is treated as an HTTP proxy.
+	// Credentials must not be included in the URL, use upstreamProxyCredentialsSecret instead.
+	// Defaults to the value of the INSIGHTS_PROXY_URL environment variable, or INSIGHTS_PROXY_DOMAIN.
+	// +optional
+	UpstreamProxy string `json:"upstreamProxy,omitempty"`
+	// The name of a Secret in this namespace whose "username" and "password" keys contain
+	// credentials for the upstream proxy. If upstreamProxy is set, only this field is used.
+	// Defaults to the value of the INSIGHTS_PROXY_CREDENTIALS_SECRET environment variable.
+	// +optional
+	UpstreamProxyCredentialsSecret string `json:"upstreamProxyCredentialsSecret,omitempty"`
+	// A comma-separated list of domains, IP addresses and CIDR ranges reached without
+	// the upstream proxy, in addition to any excluded by the OpenShift cluster-wide Proxy.
+	// Defaults to the value of the INSIGHTS_NO_PROXY environment variable.
+	// +optional
+	NoProxy string `json:"noProxy,omitempty"`
+	// The proxy implementation to deploy, either APICast or the operator's built-in proxy.
+	// Defaults to the value of the INSIGHTS_PROXY_IMPLEMENTATION environment variable,
+	// or APICast if unset and an APICast image is available for any node in the cluster.
+	// +optional
+	Implementation ProxyImplementation `json:"implementation,omitempty"`
+	// The container image used for the Insights proxy.
+	// Defaults to the value of the RELATED_IMAGE_INSIGHTS_PROXY environment variable and its
+	// per-architecture variants for APICast, or RELATED_IMAGE_INSIGHTS_BUILTIN_PROXY for the
+	// built-in proxy. If set, this image is used for all architectures the default images support.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// The name of a Secret in this namespace whose "token" key contains the Red Hat credential
+	// used to authenticate with Red Hat Insights. Required outside of OpenShift, where
+	// the global pull secret is unavailable.
+	// Defaults to the value of the INSIGHTS_TOKEN_SECRET environment variable,
+	// or the OpenShift global pull secret if unset.
+	// +optional
+	TokenSecret string `json:"tokenSecret,omitempty"`
+	// The cluster ID reported to Red Hat Insights.
+	// Defaults to the value of the INSIGHTS_CLUSTER_ID environment variable, or if unset,
+	// the OpenShift cluster ID or the UID of the kube-system namespace.
+	// +optional
+	ClusterID string `json:"clusterID,omitempty"`
+	// Resource requirements for the Insights proxy container.
+	// If unset, defaults are applied when the proxy is first created
+	// and may be modified directly on the Deployment afterwards.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// ProxyImplementation is an implementation of the Insights proxy
+// +kubebuilder:validation:Enum=APICast;Builtin
+type ProxyImplementation string
+
+const (
+	// ProxyImplementationAPICast deploys a 3scale APICast gateway as the proxy
+	ProxyImplementationAPICast ProxyImplementation = "APICast"
+	// ProxyImplementationBuiltin deploys the proxy built into the operator's image
+	ProxyImplementationBuiltin ProxyImplementation = "Builtin"
+)
+
+// InsightsProxyStatus defines the observed state of InsightsProxy
+type InsightsProxyStatus struct {
+	// Conditions of the Insights proxy.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// The URL workloads should use to send reports through the Insights proxy.
+	// +optional
+	ProxyURL string `json:"proxyURL,omitempty"`
+	// The generation of the InsightsProxy most recently reconciled.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// Condition types reported for the Insights proxy. These are reported both
+// in the InsightsProxy status and on the Insights proxy's parent config map.
+const (
+	// ConditionTypeReady indicates whether the Insights proxy is configured
+	// and available to forward reports
+	ConditionTypeReady = "Ready"
+	// ConditionTypeTokenAvailable indicates whether a token used to authenticate
+	// with Red Hat Insights could be obtained
+	ConditionTypeTokenAvailable = "TokenAvailable"
+	// ConditionTypeProxyAvailable indicates whether the Insights proxy
+	// Deployment has available replicas
+	ConditionTypeProxyAvailable = "ProxyAvailable"
+	// ConditionTypeDegraded indicates that an error occurred while
+	// reconciling the Insights proxy
+	ConditionTypeDegraded = "Degraded"
+	// ConditionTypeBackendConnected indicates whether a request sent through the
+	// Insights proxy was accepted by Red Hat Insights, checked periodically
+	ConditionTypeBackendConnected = "BackendConnected"
+)
+
+// Reasons used by the conditions above
+const (
+	ReasonReconciled                  = "Reconciled"
+	ReasonReconcileFailed             = "ReconcileFailed"
+	ReasonDisabled                    = "Disabled"
+	ReasonInvalidConfiguration        = "InvalidConfiguration"
+	ReasonTokenFound                  = "TokenFound"
+	ReasonPullSecretUnavailable       = "PullSecretUnavailable"
+	ReasonTokenMissing                = "TokenMissing"
+	ReasonTokenInvalid                = "TokenInvalid"
+	ReasonClusterVersionUnavailable   = "ClusterVersionUnavailable"
+	ReasonConfigMapFailed             = "ConfigMapFailed"
+	ReasonSecretFailed                = "SecretFailed"
+	ReasonDeploymentFailed            = "DeploymentFailed"
+	ReasonServiceFailed               = "ServiceFailed"
+	ReasonTLSFailed                   = "TLSFailed"
+	ReasonUnsupportedArchitecture     = "UnsupportedArchitecture"
+	ReasonNodesUnavailable            = "NodesUnavailable"
+	ReasonTokenSecretUnavailable      = "TokenSecretUnavailable"
+	ReasonClusterIDUnavailable        = "ClusterIDUnavailable"
+	ReasonTokenExchangeFailed         = "TokenExchangeFailed"
+	ReasonClusterProxyUnavailable     = "ClusterProxyUnavailable"
+	ReasonTrustedCAUnavailable        = "TrustedCAUnavailable"
+	ReasonProxyCredentialsUnavailable = "ProxyCredentialsUnavailable"
+	ReasonDeploymentAvailable         = "DeploymentAvailable"
+	ReasonDeploymentUnavailable       = "DeploymentUnavailable"
+	ReasonConnectivityCheckPending    = "ConnectivityCheckPending"
+	ReasonBackendAccepted             = "BackendAccepted"
+	ReasonBackendUnauthorized         = "BackendUnauthorized"
+	ReasonUserAgentForbidden          = "UserAgentForbidden"
+	ReasonBackendUnreachable          = "BackendUnreachable"
+	ReasonProxyTLSError               = "ProxyTLSError"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Enabled",type=boolean,JSONPath=`.spec.enabled`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.proxyURL`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// InsightsProxy configures the proxy used by Java workloads to send
+// reports to Red Hat Insights. Only an InsightsProxy named "insights-proxy"
+// in the operator's namespace is reconciled.
+type InsightsProxy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InsightsProxySpec   `json:"spec,omitempty"`
+	Status InsightsProxyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InsightsProxyList contains a list of InsightsProxy
+type InsightsProxyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InsightsProxy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InsightsProxy{}, &InsightsProxyList{})
+}
@@ -0,0 +1,108 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	insightsv1alpha1 "github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/config/rbac"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+	configv1 "github.com/openshift/api/config/v1"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// runDiagnose checks the preconditions of the Insights controller on the cluster
+// of the current kubeconfig, and prints the results
+func runDiagnose(args []string) {
+	var output string
+	diagConfig := &controller.DiagnoseConfig{Role: rbac.Role}
+	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	fs.StringVar(&diagConfig.Namespace, "namespace", os.Getenv("OPERATOR_NAMESPACE"),
+		"The operator's namespace. Defaults to OPERATOR_NAMESPACE.")
+	fs.StringVar(&diagConfig.OperatorName, "operator-name", os.Getenv("OPERATOR_NAME"),
+		"The name of the operator's Deployment. Defaults to OPERATOR_NAME.")
+	fs.StringVar(&diagConfig.ContainerName, "container", "",
+		"The name of the operator's container. Defaults to the first container.")
+	fs.StringVar(&output, "output", "table", "The output format, table or json.")
+	config.RegisterFlags(fs)
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(fs)
+	_ = fs.Parse(args)
+
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("diagnose")
+	s := kruntime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(configv1.AddToScheme(s))
+	utilruntime.Must(insightsv1alpha1.AddToScheme(s))
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		log.Error(err, "unable to load kubeconfig")
+		os.Exit(1)
+	}
+	diagConfig.Client, err = client.New(restConfig, client.Options{Scheme: s})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+
+	results := controller.Diagnose(context.Background(), diagConfig)
+	switch output {
+	case "json":
+		err = printDiagnosticsJSON(os.Stdout, results)
+	case "table":
+		err = printDiagnosticsTable(os.Stdout, results)
+	default:
+		err = fmt.Errorf("unknown output format %q, must be one of: table, json", output)
+	}
+	if err != nil {
+		log.Error(err, "unable to print diagnostics")
+		os.Exit(1)
+	}
+	if !controller.DiagnosticsPassed(results) {
+		os.Exit(1)
+	}
+}
+
+// printDiagnosticsTable writes one row per check
+func printDiagnosticsTable(w io.Writer, results []controller.DiagnosticResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAILS")
+	for _, result := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Check, strings.ToUpper(string(result.Status)), result.Details)
+	}
+	return tw.Flush()
+}
+
+func printDiagnosticsJSON(w io.Writer, results []controller.DiagnosticResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
@@ -0,0 +1,71 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/pkg/insights"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// runGather writes a support bundle for the Insights integration on the cluster of the current kubeconfig
+func runGather(args []string) {
+	var namespace, operatorName, output string
+	fs := flag.NewFlagSet("gather", flag.ExitOnError)
+	fs.StringVar(&namespace, "namespace", os.Getenv("OPERATOR_NAMESPACE"),
+		"The operator's namespace. Defaults to OPERATOR_NAMESPACE.")
+	fs.StringVar(&operatorName, "operator-name", os.Getenv("OPERATOR_NAME"),
+		"The name of the operator's Deployment. Defaults to OPERATOR_NAME.")
+	fs.StringVar(&output, "output", "insights-gather.tar.gz", "The path of the archive to write, or - for stdout.")
+	config.RegisterFlags(fs)
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(fs)
+	_ = fs.Parse(args)
+
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("gather")
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		log.Error(err, "unable to load kubeconfig")
+		os.Exit(1)
+	}
+	var w io.WriteCloser = os.Stdout
+	if output != "-" {
+		w, err = os.Create(output)
+		if err != nil {
+			log.Error(err, "unable to create archive", "path", output)
+			os.Exit(1)
+		}
+	}
+	// The shared proxy's namespace is read from INSIGHTS_SHARED_PROXY_NAMESPACE
+	err = insights.Gather(context.Background(), restConfig, operatorName, namespace, w)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Error(err, "unable to gather Insights data")
+		os.Exit(1)
+	}
+	if output != "-" {
+		log.Info("wrote archive", "path", output)
+	}
+}
@@ -16,17 +16,17 @@ package main
 import (
 	"crypto/tls"
 	"flag"
-	"fmt"
 	"os"
-	"runtime"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
 	"github.com/RedHatInsights/runtimes-inventory-operator/pkg/insights"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
-	configv1 "github.com/openshift/api/config/v1"
+	insightsv1alpha1 "github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	kruntime "k8s.io/apimachinery/pkg/runtime"
@@ -49,12 +49,33 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
-	utilruntime.Must(configv1.AddToScheme(scheme))
+	utilruntime.Must(insightsv1alpha1.AddToScheme(scheme))
 
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	// The built-in Insights proxy is run from the operator's image
+	if len(os.Args) > 1 && os.Args[1] == "proxy" {
+		runProxy(os.Args[2:])
+		return
+	}
+	// Prints the objects managed by the Insights controller, for review before installing
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		runRender(os.Args[2:])
+		return
+	}
+	// Checks the Insights integration on a cluster, for troubleshooting
+	if len(os.Args) > 1 && os.Args[1] == "diagnose" {
+		runDiagnose(os.Args[2:])
+		return
+	}
+	// Collects a support bundle for the Insights integration
+	if len(os.Args) > 1 && os.Args[1] == "gather" {
+		runGather(os.Args[2:])
+		return
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
@@ -102,27 +123,10 @@ func main() {
 	operatorNamespace := os.Getenv("OPERATOR_NAMESPACE")
 	userAgentPrefix := os.Getenv("USER_AGENT_PREFIX")
 
-	// Limit the cache to the operator's own namespace and the global pull secret
+	// Limit the cache to the operator's own namespace, any shared proxy namespace and the pull secret
 	cacheOpts := cache.Options{}
 	if len(operatorNamespace) > 0 {
-		cacheOpts = cache.Options{
-			ByObject: map[client.Object]cache.ByObject{
-				// Cache secret named "pull-secret" in openshift-config,
-				// in addition to any secret in the operator's namespace
-				&corev1.Secret{}: {
-					Namespaces: map[string]cache.Config{
-						"openshift-config": {
-							FieldSelector: fields.OneTermEqualSelector("metadata.name", "pull-secret"),
-						},
-						operatorNamespace: {},
-					},
-				},
-			},
-			// For all other resources, cache only objects in the operator's namespace
-			DefaultNamespaces: map[string]cache.Config{
-				operatorNamespace: {},
-			},
-		}
+		cacheOpts = newCacheOptions(operatorNamespace)
 	}
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
@@ -153,17 +157,17 @@ func main() {
 		os.Exit(1)
 	}
 
-	// TODO APICast is not currently available for arm64, disable it for now
-	if runtime.GOARCH == "arm64" {
-		setupLog.Info(fmt.Sprintf("Insights proxy unavailable for %s. Skipping setup", runtime.GOARCH))
-	} else {
-		insightsURL, err := insights.NewInsightsIntegration(mgr,
-			operatorName, operatorNamespace, userAgentPrefix, &setupLog).Setup()
-		if err != nil {
-			setupLog.Error(err, "failed to set up Insights integration")
-		} else {
-			setupLog.Info("Insights proxy set up", "url", insightsURL.String())
-		}
+	integration := insights.NewInsightsIntegration(mgr,
+		operatorName, operatorNamespace, userAgentPrefix, &setupLog)
+	insightsURL, err := integration.Setup()
+	if err != nil {
+		setupLog.Error(err, "failed to set up Insights integration")
+	} else if insightsURL != nil {
+		setupLog.Info("Insights proxy set up", "url", insightsURL.String())
+	}
+	// Opt-in webhook configuring Java workloads to use the proxy
+	if err := integration.SetupWebhook(); err != nil {
+		setupLog.Error(err, "failed to set up Insights webhook")
 	}
 
 	//+kubebuilder:scaffold:builder
@@ -183,3 +187,36 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// newCacheOptions limits the manager's cache to the operator's namespace, the namespace of a shared proxy
+// if set, and the pull secret, which is named "pull-secret" in openshift-config unless relocated by the environment
+func newCacheOptions(operatorNamespace string) cache.Options {
+	namespaces := map[string]cache.Config{
+		operatorNamespace: {},
+	}
+	// The shared proxy, and the objects configuring it, are found in the shared namespace
+	if sharedNamespace := os.Getenv(common.EnvInsightsSharedProxyNamespace); len(sharedNamespace) > 0 {
+		namespaces[sharedNamespace] = cache.Config{}
+	}
+	pullSecret := controller.NewPullSecretTokenSource(os.Getenv(common.EnvInsightsPullSecretNamespace),
+		os.Getenv(common.EnvInsightsPullSecretName)).GetSecret()
+	secretNamespaces := map[string]cache.Config{}
+	for namespace, config := range namespaces {
+		secretNamespaces[namespace] = config
+	}
+	// Cache only the pull secret in its namespace, in addition to any secret in the namespaces above
+	if _, pres := secretNamespaces[pullSecret.Namespace]; !pres {
+		secretNamespaces[pullSecret.Namespace] = cache.Config{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", pullSecret.Name),
+		}
+	}
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {
+				Namespaces: secretNamespaces,
+			},
+		},
+		// For all other resources, cache only objects in the namespaces above
+		DefaultNamespaces: namespaces,
+	}
+}
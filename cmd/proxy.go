@@ -0,0 +1,55 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/proxy"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// runProxy runs the built-in Insights proxy deployed by the Insights controller
+func runProxy(args []string) {
+	var configFile string
+	serverOpts := &proxy.ServerOptions{}
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	fs.StringVar(&configFile, "config", "", "The path to the proxy's JSON configuration file.")
+	fs.StringVar(&serverOpts.ProxyAddress, "proxy-bind-address", ":8443", "The address the HTTPS proxy binds to.")
+	fs.StringVar(&serverOpts.ManagementAddress, "health-probe-bind-address", ":8090", "The address the probe endpoint binds to.")
+	fs.StringVar(&serverOpts.MetricsAddress, "metrics-bind-address", ":9421", "The address the metric endpoint binds to.")
+	fs.StringVar(&serverOpts.TLSCertFile, "tls-cert-file", "", "The path to the proxy's serving certificate.")
+	fs.StringVar(&serverOpts.TLSKeyFile, "tls-key-file", "", "The path to the proxy's serving certificate key.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(fs)
+	_ = fs.Parse(args)
+
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("proxy")
+	config, err := proxy.LoadConfig(configFile)
+	if err != nil {
+		log.Error(err, "unable to load proxy configuration", "path", configFile)
+		os.Exit(1)
+	}
+
+	log.Info("starting proxy", "backend", config.BackendURL)
+	if err := proxy.Run(ctrl.SetupSignalHandler(), config, serverOpts, log); err != nil {
+		log.Error(err, "problem running proxy")
+		os.Exit(1)
+	}
+}
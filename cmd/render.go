@@ -0,0 +1,121 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// runRender prints the objects the Insights controller would create for the provided
+// configuration, without access to a cluster
+func runRender(args []string) {
+	var pullSecretFile, clusterIDFile, implementation string
+	config := &controller.RenderConfig{}
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	fs.StringVar(&config.Namespace, "namespace", os.Getenv("OPERATOR_NAMESPACE"),
+		"The operator's namespace, where the proxy is deployed. Defaults to OPERATOR_NAMESPACE.")
+	fs.StringVar(&config.OperatorName, "operator-name", os.Getenv("OPERATOR_NAME"),
+		"The name of the operator's Deployment. Defaults to OPERATOR_NAME.")
+	fs.StringVar(&config.UserAgentPrefix, "user-agent-prefix", os.Getenv("USER_AGENT_PREFIX"),
+		"The User-Agent prefix identifying the operator. Defaults to USER_AGENT_PREFIX.")
+	fs.StringVar(&config.BackendDomain, "backend-domain", os.Getenv(common.EnvInsightsBackendDomain),
+		"The Red Hat Insights server host. Defaults to "+common.EnvInsightsBackendDomain+".")
+	fs.StringVar(&implementation, "implementation", os.Getenv(common.EnvInsightsProxyImplementation),
+		"The proxy implementation, APICast or Builtin. Defaults to "+common.EnvInsightsProxyImplementation+".")
+	fs.StringVar(&config.ProxyImage, "image", "",
+		"The proxy image. Defaults to "+common.EnvInsightsProxyImageTag+", or "+
+			common.EnvInsightsBuiltinProxyImageTag+" for the built-in proxy.")
+	fs.StringVar(&pullSecretFile, "pull-secret-file", "",
+		"The path to the cluster's global pull secret, in .dockerconfigjson format.")
+	fs.StringVar(&clusterIDFile, "cluster-id-file", "", "The path to a file containing the cluster ID.")
+	fs.BoolVar(&config.ShowToken, "show-token", false, "Include the token in the rendered proxy configuration.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(fs)
+	_ = fs.Parse(args)
+
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("render")
+	config.Implementation = v1alpha1.ProxyImplementation(implementation)
+	if len(config.ProxyImage) == 0 {
+		if config.Implementation == v1alpha1.ProxyImplementationBuiltin {
+			config.ProxyImage = os.Getenv(common.EnvInsightsBuiltinProxyImageTag)
+		} else {
+			config.ProxyImage = os.Getenv(common.EnvInsightsProxyImageTag)
+		}
+	}
+	// Both are optional, Render reports any input the controller is missing
+	if len(pullSecretFile) > 0 {
+		pullSecret, err := os.ReadFile(pullSecretFile)
+		if err != nil {
+			log.Error(err, "unable to read pull secret", "path", pullSecretFile)
+			os.Exit(1)
+		}
+		config.PullSecret = pullSecret
+	}
+	if len(clusterIDFile) > 0 {
+		clusterID, err := os.ReadFile(clusterIDFile)
+		if err != nil {
+			log.Error(err, "unable to read cluster ID", "path", clusterIDFile)
+			os.Exit(1)
+		}
+		config.ClusterID = strings.TrimSpace(string(clusterID))
+	}
+
+	objs, err := controller.Render(context.Background(), config)
+	if err != nil {
+		log.Error(err, "unable to render Insights proxy")
+		os.Exit(1)
+	}
+	if err := printYAML(os.Stdout, objs); err != nil {
+		log.Error(err, "unable to print Insights proxy")
+		os.Exit(1)
+	}
+}
+
+// printYAML writes the objects as a multi-document YAML stream
+func printYAML(w io.Writer, objs []client.Object) error {
+	for _, obj := range objs {
+		// Show the proxy's configuration as text, so that it can be reviewed
+		if secret, ok := obj.(*corev1.Secret); ok {
+			secret.StringData = map[string]string{}
+			for key, value := range secret.Data {
+				secret.StringData[key] = string(value)
+			}
+			secret.Data = nil
+		}
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rbac provides the controller's generated RBAC manifests to the operator's binary
+package rbac
+
+import (
+	_ "embed"
+)
+
+// Role contains the ClusterRole and Role required by the controller, generated by controller-gen
+//
+//go:embed role.yaml
+var Role []byte
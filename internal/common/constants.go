@@ -16,13 +16,98 @@ package common
 
 const (
 	InsightsConfigMapName    = "insights-proxy"
+	InsightsProxyName        = InsightsConfigMapName
 	ProxyDeploymentName      = InsightsConfigMapName
 	ProxyServiceName         = ProxyDeploymentName
-	ProxyServicePort         = 8080
+	ProxyServicePort         = 8443
 	ProxySecretName          = "apicastconf"
 	EnvInsightsBackendDomain = "INSIGHTS_BACKEND_DOMAIN"
 	EnvInsightsProxyDomain   = "INSIGHTS_PROXY_DOMAIN"
 	EnvInsightsEnabled       = "INSIGHTS_ENABLED"
 	// Environment variable to override the Insights proxy image
 	EnvInsightsProxyImageTag = "RELATED_IMAGE_INSIGHTS_PROXY"
+	// Key within the Insights config map where status conditions are stored
+	InsightsConditionsKey = "conditions"
+	// Plain HTTP port APICast listens on within its pod, not exposed by the Service
+	ProxyHTTPPort = 8080
+	// Port serving the proxy's health probes
+	ProxyManagementPort = 8090
+	// Port serving the proxy's metrics within its pod
+	ProxyMetricsPort = 9421
+	// DNS domain of the cluster, used to form the fully qualified names of Services
+	DefaultClusterDomain = "cluster.local"
+	// Secret containing the serving certificate for the proxy Service
+	ProxyTLSSecretName = "insights-proxy-tls"
+	// Secret containing the operator-managed CA, when OpenShift service-serving certificates are unavailable
+	ProxyCASecretName = "insights-proxy-ca"
+	// Config map containing the CA bundle clients should use to verify the proxy
+	ProxyCABundleConfigMapName = "insights-proxy-ca"
+	// Key within the CA bundle config map holding the PEM-encoded certificates
+	ProxyCABundleKey = "service-ca.crt"
+	// Pod template annotation containing a digest of the proxy's configuration
+	ProxyConfigHashAnnotation = "runtimes-inventory.redhat.com/config-hash"
+	// Environment variable selecting the proxy implementation, APICast or Builtin
+	EnvInsightsProxyImplementation = "INSIGHTS_PROXY_IMPLEMENTATION"
+	// Environment variable to override the built-in proxy image, normally the operator's own image
+	EnvInsightsBuiltinProxyImageTag = "RELATED_IMAGE_INSIGHTS_BUILTIN_PROXY"
+	// Environment variables providing APICast images for architectures other than amd64
+	EnvInsightsProxyImageTagARM64   = EnvInsightsProxyImageTag + "_ARM64"
+	EnvInsightsProxyImageTagPPC64LE = EnvInsightsProxyImageTag + "_PPC64LE"
+	EnvInsightsProxyImageTagS390X   = EnvInsightsProxyImageTag + "_S390X"
+	// Environment variable naming a Secret in the operator's namespace containing a Red Hat token,
+	// used instead of the OpenShift global pull secret
+	EnvInsightsTokenSecret = "INSIGHTS_TOKEN_SECRET"
+	// Key within the token Secret holding the token
+	TokenSecretKey = "token"
+	// Environment variables to read the global pull secret from a location other than
+	// openshift-config/pull-secret, such as for hosted control planes
+	EnvInsightsPullSecretNamespace = "INSIGHTS_PULL_SECRET_NAMESPACE"
+	EnvInsightsPullSecretName      = "INSIGHTS_PULL_SECRET_NAME"
+	// Environment variable naming a Secret in the operator's namespace containing credentials
+	// to exchange for access tokens with Red Hat SSO
+	EnvInsightsSSOSecret = "INSIGHTS_SSO_SECRET"
+	// Environment variable to override the Red Hat SSO token endpoint
+	EnvInsightsSSOTokenURL = "INSIGHTS_SSO_TOKEN_URL"
+	// Keys within the SSO Secret holding either an offline token, or a service account's credentials
+	SSOOfflineTokenKey = "offline_token"
+	SSOClientIDKey     = "client_id"
+	SSOClientSecretKey = "client_secret"
+	// Config map containing the CA bundle the proxy trusts for outgoing connections
+	ProxyTrustedCABundleConfigMapName = "insights-proxy-trusted-ca"
+	// Key within trusted CA bundle config maps holding the PEM-encoded certificates
+	TrustedCABundleKey = "ca-bundle.crt"
+	// Environment variable naming a config map in the operator's namespace containing a CA bundle
+	// for the proxy to trust, used instead of the OpenShift cluster's trusted CA bundle
+	EnvInsightsTrustedCAConfigMap = "INSIGHTS_TRUSTED_CA_CONFIGMAP"
+	// Environment variable to explicitly set the cluster ID reported to Red Hat Insights
+	EnvInsightsClusterID = "INSIGHTS_CLUSTER_ID"
+	// Environment variable containing the URL of the upstream proxy used to reach the Insights backend,
+	// preferred over EnvInsightsProxyDomain
+	EnvInsightsProxyURL = "INSIGHTS_PROXY_URL"
+	// Environment variable naming a Secret in the operator's namespace containing
+	// credentials for the upstream proxy
+	EnvInsightsProxyCredentialsSecret = "INSIGHTS_PROXY_CREDENTIALS_SECRET"
+	// Environment variable containing a comma-separated list of destinations
+	// reached without the upstream proxy
+	EnvInsightsNoProxy = "INSIGHTS_NO_PROXY"
+	// Environment variable enabling the webhook that configures Java workloads to report through the proxy
+	EnvInsightsWebhookEnabled = "INSIGHTS_WEBHOOK_ENABLED"
+	// Environment variable providing the image containing the Insights Java agent injected by the webhook
+	EnvInsightsJavaAgentImageTag = "RELATED_IMAGE_INSIGHTS_JAVA_AGENT"
+	// Environment variable to override the location of the agent JAR within its image
+	EnvInsightsJavaAgentPath = "INSIGHTS_JAVA_AGENT_PATH"
+	// Label on pods or namespaces selecting them for configuration by the webhook
+	InjectJavaLabel = "runtimes-inventory.redhat.com/inject-java"
+	// Pod annotation set to "false" to inject only the client configuration, without the agent
+	InjectJavaAgentAnnotation = "runtimes-inventory.redhat.com/inject-java-agent"
+	// Pod annotation recording that the webhook configured the pod
+	JavaInjectedAnnotation = "runtimes-inventory.redhat.com/java-injected"
+	// Config map created by the webhook in the namespaces of configured pods, containing
+	// a Java trust store with the proxy's CA
+	JavaTrustStoreConfigMapName = "insights-java-truststore"
+	// Key within the Java trust store config map holding the trust store in JKS format
+	JavaTrustStoreKey = "truststore.jks"
+	// Environment variable naming a namespace where the Insights proxy is shared with other operators
+	// configured with the same namespace, rather than deploying a proxy in the operator's namespace
+	EnvInsightsSharedProxyNamespace = "INSIGHTS_SHARED_PROXY_NAMESPACE"
 )
@@ -0,0 +1,106 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// Recommended labels applied to the objects managed for an Insights integration
+	NameLabel      = "app.kubernetes.io/name"
+	InstanceLabel  = "app.kubernetes.io/instance"
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// Name of the controller reconciling the default instance
+	ControllerName = "insights"
+)
+
+// ResourceNames are the names of the objects managed for an instance of the Insights integration.
+// Instances other than the default prefix these names with their own, so that integrations
+// embedded in several operators may share a namespace.
+type ResourceNames struct {
+	// Name of the instance, empty for the default instance
+	Instance string
+	// Parent of all other objects, and where status conditions are reported
+	ConfigMap string
+	// InsightsProxy resource configuring the instance
+	InsightsProxy string
+	// Deployment and Service of the proxy
+	Deployment string
+	Service    string
+	// Secret containing the proxy's configuration
+	ProxySecret string
+	// Secret containing the proxy's serving certificate
+	TLSSecret string
+	// Secret containing the operator-managed CA
+	CASecret string
+	// Config map containing the CA bundle clients should use to verify the proxy
+	CABundleConfigMap string
+	// Config map containing the CA bundle the proxy trusts for outgoing connections
+	TrustedCABundleConfigMap string
+	// Config map in the namespaces of Java workloads containing a trust store with the proxy's CA
+	JavaTrustStoreConfigMap string
+	// Name of the controller reconciling the instance
+	Controller string
+}
+
+// NewResourceNames returns the names of the objects managed for the instance.
+// An empty instance name returns the default names.
+func NewResourceNames(instance string) *ResourceNames {
+	prefix := ""
+	if len(instance) > 0 {
+		prefix = instance + "-"
+	}
+	return &ResourceNames{
+		Instance:                 instance,
+		ConfigMap:                prefix + InsightsConfigMapName,
+		InsightsProxy:            prefix + InsightsProxyName,
+		Deployment:               prefix + ProxyDeploymentName,
+		Service:                  prefix + ProxyServiceName,
+		ProxySecret:              prefix + ProxySecretName,
+		TLSSecret:                prefix + ProxyTLSSecretName,
+		CASecret:                 prefix + ProxyCASecretName,
+		CABundleConfigMap:        prefix + ProxyCABundleConfigMapName,
+		TrustedCABundleConfigMap: prefix + ProxyTrustedCABundleConfigMapName,
+		JavaTrustStoreConfigMap:  prefix + JavaTrustStoreConfigMapName,
+		Controller:               prefix + ControllerName,
+	}
+}
+
+// Validate returns an error if the instance name cannot be used to name its objects
+func (n *ResourceNames) Validate() error {
+	// The Service name is the most restrictive
+	if errs := validation.IsDNS1035Label(n.Service); len(errs) > 0 {
+		return fmt.Errorf("invalid Insights instance name %q: %s", n.Instance, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Labels returns the labels identifying the objects of this instance,
+// managed by the named operator
+func (n *ResourceNames) Labels(managedBy string) map[string]string {
+	labels := map[string]string{
+		"app":         n.Deployment,
+		NameLabel:     ProxyDeploymentName,
+		InstanceLabel: n.Deployment,
+	}
+	if len(managedBy) > 0 {
+		labels[ManagedByLabel] = managedBy
+	}
+	return labels
+}
@@ -0,0 +1,106 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package common
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+)
+
+// Label on a namespace setting the Pod Security Admission level enforced for its pods
+const PodSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+// Pod Security Standard levels
+const (
+	PodSecurityLevelPrivileged = "privileged"
+	PodSecurityLevelBaseline   = "baseline"
+	PodSecurityLevelRestricted = "restricted"
+)
+
+// First Kubernetes version of OpenShift 4.11, which introduced the restricted-v2 SCC
+var openShift411 = version.MajorMinor(1, 24)
+
+// Platform describes the cluster the operator runs on
+type Platform struct {
+	// Whether the cluster is OpenShift
+	OpenShift bool
+	// Whether SecurityContextConstraints assign pods' user and group IDs
+	SCC bool
+	// Kubernetes version of the API server, nil if unknown
+	Version *version.Version
+}
+
+// DetectPlatform discovers the platform using the cluster's discovery API
+func DetectPlatform(client discovery.DiscoveryInterface) (*Platform, error) {
+	platform := &Platform{}
+	info, err := client.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	platform.Version, err = version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups.Groups {
+		switch group.Name {
+		case "config.openshift.io":
+			platform.OpenShift = true
+		case "security.openshift.io":
+			platform.SCC = true
+		}
+	}
+	return platform, nil
+}
+
+// IsOpenShiftBefore411 returns whether the platform is a version of OpenShift
+// prior to 4.11, when Pod Security Admission fields were not yet allowed by its SCCs
+func (p *Platform) IsOpenShiftBefore411() bool {
+	return p.OpenShift && p.Version != nil && p.Version.LessThan(openShift411)
+}
+
+// SeccompProfile returns a SeccompProfile for the restricted Pod Security Standard,
+// given the level enforced in the pod's namespace. On OpenShift < 4.11, this is left
+// empty for backwards-compatibility unless the restricted level is enforced.
+// TODO Remove the OpenShift < 4.11 case once support is dropped
+func (p *Platform) SeccompProfile(enforceLevel string) *corev1.SeccompProfile {
+	if p.IsOpenShiftBefore411() && enforceLevel != PodSecurityLevelRestricted {
+		return nil
+	}
+	return &corev1.SeccompProfile{
+		Type: corev1.SeccompProfileTypeRuntimeDefault,
+	}
+}
+
+// PodSecurityContext returns a security context for pods running as the provided
+// non-root user, compatible with the restricted Pod Security Standard. Where SCCs
+// are available, they assign the user and group IDs from the namespace's range.
+func (p *Platform) PodSecurityContext(enforceLevel string, uid int64) *corev1.PodSecurityContext {
+	nonRoot := true
+	context := &corev1.PodSecurityContext{
+		RunAsNonRoot:   &nonRoot,
+		SeccompProfile: p.SeccompProfile(enforceLevel),
+	}
+	if !p.SCC {
+		// Mounted volumes must be readable by the pod's user
+		context.RunAsUser = &uid
+		context.FSGroup = &uid
+	}
+	return context
+}
@@ -15,90 +15,420 @@
 package controller
 
 import (
-	"bytes"
-	"text/template"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/proxy"
 )
 
 type apiCastConfigParams struct {
-	FrontendDomains       string
+	FrontendDomains       []string
 	BackendInsightsDomain string
 	HeaderValue           string
 	UserAgent             string
-	ProxyDomain           string
-}
-
-var apiCastConfigTemplate = template.Must(template.New("").Parse(`{
-  "services": [
-    {
-      "id": "1",
-      "backend_version": "1",
-      "proxy": {
-        "hosts": [{{ .FrontendDomains }}],
-        "api_backend": "https://{{ .BackendInsightsDomain }}:443/",
-        "backend": { "endpoint": "http://127.0.0.1:8081", "host": "backend" },
-        "policy_chain": [
-          {
-            "name": "default_credentials",
-            "version": "builtin",
-            "configuration": {
-              "auth_type": "user_key",
-              "user_key": "dummy_key"
-            }
-          },
-          {{- if .ProxyDomain }}
-          {
-            "name": "apicast.policy.http_proxy",
-            "configuration": {
-              "https_proxy": "http://{{ .ProxyDomain }}/",
-              "http_proxy": "http://{{ .ProxyDomain }}/"
-            }
-          },
-          {{- end }}
-          {
-            "name": "headers",
-            "version": "builtin",
-            "configuration": {
-              "request": [
-                {
-                  "op": "set",
-                  "header": "Authorization",
-                  "value_type": "plain",
-                  "value": "Bearer {{ .HeaderValue }}"
-                },
-                {
-                  "op": "set",
-                  "header": "User-Agent",
-                  "value_type": "plain",
-                  "value": "{{ .UserAgent }}"
-                }
-              ]
-            }
-          },
-          {
-            "name": "apicast.policy.apicast"
-          }
-        ],
-        "proxy_rules": [
-          {
-            "http_method": "POST",
-            "pattern": "/",
-            "metric_system_name": "hits",
-            "delta": 1,
-            "parameters": [],
-            "querystring_parameters": {}
-          }
-        ]
-      }
-    }
-  ]
-}`))
+	// Operators sharing the proxy, whose requests are reported with their own User-Agent
+	Members []proxy.Member
+	// Upstream proxy used to reach the backend, if any
+	ProxyURL string
+	// Additional policies inserted into the policy chain
+	Policies []APICastPolicyExtension
+}
+
+// Names of the APICast policies in the generated policy chain
+const (
+	APICastPolicyDefaultCredentials = "default_credentials"
+	APICastPolicyHTTPProxy          = "apicast.policy.http_proxy"
+	APICastPolicyHeaders            = "headers"
+	APICastPolicyConditional        = "conditional"
+	APICastPolicyURLRewriting       = "url_rewriting"
+	APICastPolicyAPICast            = "apicast.policy.apicast"
+	// Version of policies bundled with APICast
+	APICastPolicyVersionBuiltin = "builtin"
+	// Prefix optionally used in the names of policies bundled with APICast
+	apiCastPolicyPrefix = "apicast.policy."
+)
+
+// APICastPolicyPosition is a point in the generated policy chain where additional policies are inserted
+type APICastPolicyPosition string
+
+const (
+	// Before all generated policies
+	APICastPolicyPositionFirst APICastPolicyPosition = "First"
+	// After the default credentials and upstream proxy, before the Authorization
+	// and User-Agent headers are set
+	APICastPolicyPositionBeforeHeaders APICastPolicyPosition = "BeforeHeaders"
+	// After the Authorization and User-Agent headers are set, before the request is proxied
+	APICastPolicyPositionAfterHeaders APICastPolicyPosition = "AfterHeaders"
+	// After all generated policies
+	APICastPolicyPositionLast APICastPolicyPosition = "Last"
+)
+
+// APICastPolicyExtension is an additional policy inserted into the generated policy chain
+type APICastPolicyExtension struct {
+	// Where the policy is inserted. Policies sharing a position are inserted in the order provided.
+	Position APICastPolicyPosition
+	Policy   APICastPolicy
+}
+
+// BuiltinAPICastPolicies are the names of the policies bundled with
+// the APICast images supported by the Insights proxy
+var BuiltinAPICastPolicies = []string{
+	"3scale_batcher", "3scale_referrer", "apicast", "caching", "camel", "conditional", "content_caching",
+	"cors", "custom_metrics", "default_credentials", "echo", "grpc", "headers", "http_proxy", "ip_check",
+	"jwt_claim_check", "keycloak_role_check", "liquid_context_debug", "logging", "maintenance_mode",
+	"nginx_filters", "oauth_mtls", "on_failed", "payload_limits", "rate_limit", "rate_limit_headers",
+	"request_unbuffered", "response_request_content_limits", "retry", "rewrite_url_captures", "routing",
+	"soap", "statuscode_overwrite", "tls", "tls_validation", "token_introspection", "upstream",
+	"upstream_connection", "url_rewriting", "websocket",
+}
+
+// Policies whose configuration is managed by the Insights controller,
+// which may not be added again
+var managedAPICastPolicies = []string{"apicast", "default_credentials", "http_proxy"}
+
+// APICastConfig is the configuration loaded by APICast from its config.json
+type APICastConfig struct {
+	Services []APICastService `json:"services"`
+}
+
+// APICastService is an API exposed by APICast
+type APICastService struct {
+	ID             string       `json:"id"`
+	BackendVersion string       `json:"backend_version"`
+	Proxy          APICastProxy `json:"proxy"`
+}
+
+// APICastProxy configures how APICast forwards requests for a service
+type APICastProxy struct {
+	// Host names clients use to reach the service
+	Hosts []string `json:"hosts"`
+	// URL requests are forwarded to
+	APIBackend string `json:"api_backend"`
+	// 3scale backend used to authorize requests
+	Backend *APICastBackend `json:"backend,omitempty"`
+	// Policies applied to each request, in order
+	PolicyChain []APICastPolicy `json:"policy_chain"`
+	// Requests accepted by the service
+	ProxyRules []APICastProxyRule `json:"proxy_rules"`
+}
+
+// APICastBackend is the 3scale backend used to authorize requests
+type APICastBackend struct {
+	Endpoint string `json:"endpoint"`
+	Host     string `json:"host"`
+}
+
+// APICastPolicy is an entry in a policy chain
+type APICastPolicy struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Configuration of the policy, which must marshal to a JSON object.
+	// When parsed, this is a map[string]interface{}.
+	Configuration interface{} `json:"configuration,omitempty"`
+}
+
+// APICastDefaultCredentialsConfig configures the default_credentials policy
+type APICastDefaultCredentialsConfig struct {
+	AuthType string `json:"auth_type"`
+	UserKey  string `json:"user_key"`
+}
+
+// APICastHTTPProxyConfig configures the apicast.policy.http_proxy policy
+type APICastHTTPProxyConfig struct {
+	HTTPSProxy string `json:"https_proxy,omitempty"`
+	HTTPProxy  string `json:"http_proxy,omitempty"`
+}
+
+// APICastHeadersConfig configures the headers policy
+type APICastHeadersConfig struct {
+	Request  []APICastHeaderOperation `json:"request,omitempty"`
+	Response []APICastHeaderOperation `json:"response,omitempty"`
+}
+
+// APICastHeaderOperation modifies a header of a request or response
+type APICastHeaderOperation struct {
+	Op        string `json:"op"`
+	Header    string `json:"header"`
+	ValueType string `json:"value_type"`
+	Value     string `json:"value"`
+}
+
+// APICastConditionalConfig configures the conditional policy, which applies
+// its policy chain only to requests matching the condition
+type APICastConditionalConfig struct {
+	Condition   APICastCondition `json:"condition"`
+	PolicyChain []APICastPolicy  `json:"policy_chain"`
+}
+
+// APICastCondition combines the results of its operations
+type APICastCondition struct {
+	Operations []APICastOperation `json:"operations"`
+	CombineOp  string             `json:"combine_op"`
+}
+
+// APICastOperation compares two values, which may be Liquid templates
+type APICastOperation struct {
+	Left      string `json:"left"`
+	LeftType  string `json:"left_type"`
+	Op        string `json:"op"`
+	Right     string `json:"right"`
+	RightType string `json:"right_type"`
+}
+
+// APICastURLRewritingConfig configures the url_rewriting policy
+type APICastURLRewritingConfig struct {
+	Commands []APICastURLRewritingCommand `json:"commands"`
+}
+
+// APICastURLRewritingCommand substitutes the first match of a regular expression in the path
+type APICastURLRewritingCommand struct {
+	Op      string `json:"op"`
+	Regex   string `json:"regex"`
+	Replace string `json:"replace"`
+}
+
+// APICastProxyRule matches requests accepted by a service
+type APICastProxyRule struct {
+	HTTPMethod            string            `json:"http_method"`
+	Pattern               string            `json:"pattern"`
+	MetricSystemName      string            `json:"metric_system_name"`
+	Delta                 int               `json:"delta"`
+	Parameters            []string          `json:"parameters"`
+	QuerystringParameters map[string]string `json:"querystring_parameters"`
+}
+
+// ParseAPICastConfig reads an APICast configuration, such as one from an existing Secret
+func ParseAPICastConfig(data []byte) (*APICastConfig, error) {
+	config := &APICastConfig{}
+	err := json.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse APICast configuration: %w", err)
+	}
+	return config, nil
+}
+
+// Marshal returns the configuration as APICast's config.json
+func (c *APICastConfig) Marshal() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
+
+// Equal returns whether both configurations would be marshalled to equivalent JSON,
+// regardless of formatting or the types used for policy configurations
+func (c *APICastConfig) Equal(other *APICastConfig) bool {
+	normalize := func(config *APICastConfig) (interface{}, error) {
+		buf, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		var result interface{}
+		err = json.Unmarshal(buf, &result)
+		return result, err
+	}
+	left, err := normalize(c)
+	if err != nil {
+		return false
+	}
+	right, err := normalize(other)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func newAPICastConfig(params *apiCastConfigParams) *APICastConfig {
+	extensions := map[APICastPolicyPosition][]APICastPolicy{}
+	for _, extension := range params.Policies {
+		extensions[extension.Position] = append(extensions[extension.Position], extension.Policy)
+	}
+
+	policies := append([]APICastPolicy{}, extensions[APICastPolicyPositionFirst]...)
+	policies = append(policies,
+		APICastPolicy{
+			Name:    APICastPolicyDefaultCredentials,
+			Version: APICastPolicyVersionBuiltin,
+			Configuration: &APICastDefaultCredentialsConfig{
+				AuthType: "user_key",
+				UserKey:  "dummy_key",
+			},
+		},
+	)
+	if len(params.ProxyURL) > 0 {
+		policies = append(policies, APICastPolicy{
+			Name: APICastPolicyHTTPProxy,
+			Configuration: &APICastHTTPProxyConfig{
+				HTTPSProxy: params.ProxyURL + "/",
+				HTTPProxy:  params.ProxyURL + "/",
+			},
+		})
+	}
+	policies = append(policies, extensions[APICastPolicyPositionBeforeHeaders]...)
+	policies = append(policies,
+		APICastPolicy{
+			Name:    APICastPolicyHeaders,
+			Version: APICastPolicyVersionBuiltin,
+			Configuration: &APICastHeadersConfig{
+				Request: []APICastHeaderOperation{
+					{
+						Op:        "set",
+						Header:    "Authorization",
+						ValueType: "plain",
+						Value:     "Bearer " + params.HeaderValue,
+					},
+					{
+						Op:        "set",
+						Header:    "User-Agent",
+						ValueType: "plain",
+						Value:     params.UserAgent,
+					},
+				},
+			},
+		},
+	)
+	// Requests of operators sharing the proxy replace the User-Agent with their own,
+	// and have their path prefix removed
+	for _, member := range params.Members {
+		prefix := "^" + regexp.QuoteMeta(member.PathPrefix)
+		policies = append(policies, APICastPolicy{
+			Name:    APICastPolicyConditional,
+			Version: APICastPolicyVersionBuiltin,
+			Configuration: &APICastConditionalConfig{
+				Condition: APICastCondition{
+					Operations: []APICastOperation{
+						{
+							Left:      "{{ uri }}",
+							LeftType:  "liquid",
+							Op:        "matches",
+							Right:     prefix + "/",
+							RightType: "plain",
+						},
+					},
+					CombineOp: "and",
+				},
+				PolicyChain: []APICastPolicy{
+					{
+						Name:    APICastPolicyHeaders,
+						Version: APICastPolicyVersionBuiltin,
+						Configuration: &APICastHeadersConfig{
+							Request: []APICastHeaderOperation{
+								{
+									Op:        "set",
+									Header:    "User-Agent",
+									ValueType: "plain",
+									Value:     member.UserAgent,
+								},
+							},
+						},
+					},
+					{
+						Name:    APICastPolicyURLRewriting,
+						Version: APICastPolicyVersionBuiltin,
+						Configuration: &APICastURLRewritingConfig{
+							Commands: []APICastURLRewritingCommand{
+								{Op: "sub", Regex: prefix, Replace: ""},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	policies = append(policies, extensions[APICastPolicyPositionAfterHeaders]...)
+	policies = append(policies, APICastPolicy{
+		Name: APICastPolicyAPICast,
+	})
+	policies = append(policies, extensions[APICastPolicyPositionLast]...)
+
+	return &APICastConfig{
+		Services: []APICastService{
+			{
+				ID:             "1",
+				BackendVersion: "1",
+				Proxy: APICastProxy{
+					Hosts:      params.FrontendDomains,
+					APIBackend: fmt.Sprintf("https://%s:443/", params.BackendInsightsDomain),
+					Backend: &APICastBackend{
+						Endpoint: "http://127.0.0.1:8081",
+						Host:     "backend",
+					},
+					PolicyChain: policies,
+					ProxyRules: []APICastProxyRule{
+						{
+							HTTPMethod:            http.MethodPost,
+							Pattern:               "/",
+							MetricSystemName:      "hits",
+							Delta:                 1,
+							Parameters:            []string{},
+							QuerystringParameters: map[string]string{},
+						},
+					},
+				},
+			},
+		},
+	}
+}
 
 func getAPICastConfig(params *apiCastConfigParams) (*string, error) {
-	buf := &bytes.Buffer{}
-	err := apiCastConfigTemplate.Execute(buf, params)
+	buf, err := newAPICastConfig(params).Marshal()
 	if err != nil {
 		return nil, err
 	}
-	result := buf.String()
+	result := string(buf)
 	return &result, nil
 }
+
+// validateAPICastPolicies returns an error if any additional policy is unknown to APICast,
+// or would interfere with the generated policy chain. Custom policies provided by the
+// APICast image are also accepted.
+func validateAPICastPolicies(extensions []APICastPolicyExtension, customPolicies []string) error {
+	for _, extension := range extensions {
+		policy := extension.Policy
+		switch extension.Position {
+		case APICastPolicyPositionFirst, APICastPolicyPositionBeforeHeaders,
+			APICastPolicyPositionAfterHeaders, APICastPolicyPositionLast:
+		default:
+			return fmt.Errorf("unknown position %q for APICast policy %q", extension.Position, policy.Name)
+		}
+		if len(policy.Name) == 0 {
+			return errors.New("APICast policy name must not be empty")
+		}
+
+		// The version of a custom policy depends on the image
+		if !containsString(customPolicies, policy.Name) {
+			name := strings.TrimPrefix(policy.Name, apiCastPolicyPrefix)
+			if !containsString(BuiltinAPICastPolicies, name) {
+				return fmt.Errorf("unknown APICast policy %q", policy.Name)
+			}
+			if len(policy.Version) > 0 && policy.Version != APICastPolicyVersionBuiltin {
+				return fmt.Errorf("APICast policy %q must have version %q", policy.Name, APICastPolicyVersionBuiltin)
+			}
+			if containsString(managedAPICastPolicies, name) {
+				return fmt.Errorf("APICast policy %q is managed by the Insights controller", policy.Name)
+			}
+		}
+
+		if policy.Configuration != nil {
+			buf, err := json.Marshal(policy.Configuration)
+			if err != nil {
+				return fmt.Errorf("invalid configuration for APICast policy %q: %w", policy.Name, err)
+			}
+			if !strings.HasPrefix(string(buf), "{") {
+				return fmt.Errorf("configuration for APICast policy %q must be a JSON object", policy.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Node architectures the proxy may be scheduled on
+const (
+	archAMD64   = "amd64"
+	archARM64   = "arm64"
+	archPPC64LE = "ppc64le"
+	archS390X   = "s390x"
+)
+
+// Architectures the operator's image, and so the built-in proxy, is published for
+var builtinProxyArchitectures = []string{archAMD64, archARM64, archPPC64LE, archS390X}
+
+// getProxyImages returns the proxy image to use for each supported node architecture
+func (r *InsightsReconciler) getProxyImages(implementation v1alpha1.ProxyImplementation,
+	override string) map[string]string {
+	images := map[string]string{}
+	if implementation == v1alpha1.ProxyImplementationBuiltin {
+		if len(r.builtinProxyImageTag) > 0 {
+			for _, arch := range builtinProxyArchitectures {
+				images[arch] = r.builtinProxyImageTag
+			}
+		}
+	} else {
+		for arch, image := range r.proxyImageTags {
+			images[arch] = image
+		}
+	}
+	// An image from the InsightsProxy replaces the defaults
+	if len(override) > 0 {
+		for arch := range images {
+			images[arch] = override
+		}
+	}
+	return images
+}
+
+// getNodeArchitectures returns the number of nodes in the cluster for each architecture
+func (r *InsightsReconciler) getNodeArchitectures(ctx context.Context) (map[string]int, error) {
+	// Only the nodes' labels are needed
+	nodes := &metav1.PartialObjectMetadataList{}
+	nodes.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("NodeList"))
+	err := r.Client.List(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+	archs := map[string]int{}
+	for _, node := range nodes.Items {
+		arch, pres := node.Labels[corev1.LabelArchStable]
+		if pres {
+			archs[arch]++
+		}
+	}
+	return archs, nil
+}
+
+// placeProxy selects the proxy implementation, if not already chosen, and the image to deploy
+// along with the node architectures it can be scheduled on
+func (r *InsightsReconciler) placeProxy(ctx context.Context, config *proxyConfig) error {
+	nodeArchs, err := r.getNodeArchitectures(ctx)
+	if err != nil {
+		return newReconcileError(v1alpha1.ReasonNodesUnavailable, err)
+	}
+
+	if len(config.implementation) == 0 {
+		// Prefer APICast, unless no node can run it
+		config.implementation = v1alpha1.ProxyImplementationAPICast
+		apiCastImages := r.getProxyImages(v1alpha1.ProxyImplementationAPICast, config.imageOverride)
+		if _, _, err := selectProxyImage(apiCastImages, nodeArchs); err != nil {
+			config.implementation = v1alpha1.ProxyImplementationBuiltin
+		}
+	}
+
+	images := r.getProxyImages(config.implementation, config.imageOverride)
+	if len(images) == 0 {
+		return newReconcileError(v1alpha1.ReasonInvalidConfiguration,
+			fmt.Errorf("no proxy image tag provided for Insights %s proxy", config.implementation))
+	}
+	config.proxyImageTag, config.architectures, err = selectProxyImage(images, nodeArchs)
+	if err != nil {
+		return newReconcileError(v1alpha1.ReasonUnsupportedArchitecture, err)
+	}
+	return nil
+}
+
+// selectProxyImage chooses the image able to run on the most nodes, returning it
+// with the architectures it is used for. A Deployment has a single image, so when
+// architectures use different images, only nodes of one of them are used.
+func selectProxyImage(images map[string]string, nodeArchs map[string]int) (string, []string, error) {
+	candidates := []string{}
+	for arch := range images {
+		// If no nodes report their architecture, assume any image may be used
+		if len(nodeArchs) == 0 || nodeArchs[arch] > 0 {
+			candidates = append(candidates, arch)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil, fmt.Errorf("no Insights proxy image available for node architectures: %s",
+			strings.Join(sortedKeys(nodeArchs), ", "))
+	}
+	sort.Strings(candidates)
+
+	// Architectures sharing an image are counted together
+	nodesPerImage := map[string]int{}
+	for _, arch := range candidates {
+		nodesPerImage[images[arch]] += nodeArchs[arch]
+	}
+	// Prefer the first architecture in order when tied
+	image := images[candidates[0]]
+	for _, arch := range candidates {
+		if nodesPerImage[images[arch]] > nodesPerImage[image] {
+			image = images[arch]
+		}
+	}
+
+	archs := []string{}
+	for _, arch := range sortedKeys(images) {
+		if images[arch] == image {
+			archs = append(archs, arch)
+		}
+	}
+	return image, archs, nil
+}
+
+// newArchitectureAffinity restricts scheduling to nodes of the provided architectures
+func newArchitectureAffinity(archs []string) *corev1.Affinity {
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      corev1.LabelArchStable,
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   archs,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,103 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/proxy"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// parseProxyImplementation parses the proxy implementation chosen by environment variable.
+// When none is chosen, an empty implementation is returned and one is selected
+// based on the cluster's nodes.
+func parseProxyImplementation(value string) (v1alpha1.ProxyImplementation, error) {
+	switch {
+	case len(value) == 0:
+		return "", nil
+	case strings.EqualFold(value, string(v1alpha1.ProxyImplementationAPICast)):
+		return v1alpha1.ProxyImplementationAPICast, nil
+	case strings.EqualFold(value, string(v1alpha1.ProxyImplementationBuiltin)):
+		return v1alpha1.ProxyImplementationBuiltin, nil
+	default:
+		return "", fmt.Errorf("unknown Insights proxy implementation %q, must be one of: %s, %s", value,
+			v1alpha1.ProxyImplementationAPICast, v1alpha1.ProxyImplementationBuiltin)
+	}
+}
+
+func getBuiltinProxyConfig(params *apiCastConfigParams) (*string, error) {
+	config := &proxy.Config{
+		BackendURL:     "https://" + params.BackendInsightsDomain,
+		Token:          params.HeaderValue,
+		UserAgent:      params.UserAgent,
+		AllowedMethods: proxy.DefaultAllowedMethods,
+		AllowedPaths:   proxy.DefaultAllowedPaths,
+		Members:        params.Members,
+	}
+	if len(params.ProxyURL) > 0 {
+		config.UpstreamProxyURL = params.ProxyURL
+	}
+	// Catch problems now rather than when the proxy starts
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	result := string(buf)
+	return &result, nil
+}
+
+func setBuiltinProxyContainer(container *corev1.Container, ports ProxyPorts) {
+	// The built-in proxy is a subcommand of the operator's binary
+	container.Args = []string{
+		"proxy",
+		"--config=" + path.Join(configMountPath, "config.json"),
+		"--tls-cert-file=" + path.Join(tlsMountPath, corev1.TLSCertKey),
+		"--tls-key-file=" + path.Join(tlsMountPath, corev1.TLSPrivateKeyKey),
+	}
+	// Only set when changed, to avoid rolling existing proxies
+	if ports.HTTPS != DefaultProxyPorts.HTTPS {
+		container.Args = append(container.Args, fmt.Sprintf("--proxy-bind-address=:%d", ports.HTTPS))
+	}
+	if ports.Management != DefaultProxyPorts.Management {
+		container.Args = append(container.Args, fmt.Sprintf("--health-probe-bind-address=:%d", ports.Management))
+	}
+	if ports.Metrics != DefaultProxyPorts.Metrics {
+		container.Args = append(container.Args, fmt.Sprintf("--metrics-bind-address=:%d", ports.Metrics))
+	}
+	container.Env = nil
+	container.Ports = []corev1.ContainerPort{
+		{
+			Name:          "https",
+			ContainerPort: ports.HTTPS,
+		},
+		{
+			Name:          "management",
+			ContainerPort: ports.Management,
+		},
+		{
+			Name:          "metrics",
+			ContainerPort: ports.Metrics,
+		},
+	}
+}
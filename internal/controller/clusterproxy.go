@@ -0,0 +1,66 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"net/url"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"golang.org/x/net/http/httpproxy"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Name of the OpenShift cluster-wide Proxy configuration
+const clusterProxyName = "cluster"
+
+// getClusterProxy returns the OpenShift cluster-wide Proxy, or nil if there is none
+func (r *InsightsReconciler) getClusterProxy(ctx context.Context) (*configv1.Proxy, error) {
+	openshift, err := IsOpenShift(r.Client.RESTMapper())
+	if err != nil || !openshift {
+		return nil, err
+	}
+	proxy := &configv1.Proxy{}
+	err = r.Client.Get(ctx, types.NamespacedName{Name: clusterProxyName}, proxy)
+	if err != nil {
+		if kerrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// selectUpstreamProxy returns the proxy for HTTPS requests to the backend domain
+// from the cluster-wide Proxy configuration, or nil if the domain is excluded
+// by its no-proxy list
+func selectUpstreamProxy(status *configv1.ProxyStatus, backendDomain string) (*url.URL, error) {
+	return proxyForBackend(&httpproxy.Config{
+		HTTPProxy:  status.HTTPProxy,
+		HTTPSProxy: status.HTTPSProxy,
+		NoProxy:    status.NoProxy,
+	}, backendDomain)
+}
+
+func (r *InsightsReconciler) isClusterProxy(ctx context.Context, proxy client.Object) []reconcile.Request {
+	if proxy.GetName() != clusterProxyName {
+		return nil
+	}
+	return r.proxyDeploymentRequest()
+}
@@ -0,0 +1,216 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/proxy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultConnectivityCheckInterval is how often a request is sent through the Insights proxy
+// to verify that Red Hat Insights accepts its credentials, unless configured otherwise
+const DefaultConnectivityCheckInterval = 10 * time.Minute
+
+const (
+	// Path of the upload endpoint the proxy forwards to Red Hat Insights. The check sends
+	// an empty upload, which is rejected without being stored once it is authenticated.
+	connectivityCheckPath = "/api/ingress/v1/upload"
+	// Kept short, since the check blocks reconciliation until it completes
+	connectivityCheckTimeout = 3 * time.Second
+)
+
+// ConnectivityResult classifies the outcome of a connectivity check
+type ConnectivityResult string
+
+const (
+	// ConnectivityOK indicates the request was authenticated by Red Hat Insights
+	ConnectivityOK ConnectivityResult = "ok"
+	// ConnectivityUnauthorized indicates Red Hat Insights rejected the proxy's token,
+	// for instance because the pull secret was revoked
+	ConnectivityUnauthorized ConnectivityResult = "unauthorized"
+	// ConnectivityForbiddenPrefix indicates Red Hat Insights refused the proxy's
+	// User-Agent, for instance because its prefix is not approved
+	ConnectivityForbiddenPrefix ConnectivityResult = "forbidden_prefix"
+	// ConnectivityUnreachable indicates the proxy or Red Hat Insights could not be reached
+	ConnectivityUnreachable ConnectivityResult = "unreachable"
+	// ConnectivityTLSError indicates the proxy's certificate could not be verified, or the
+	// proxy could not verify the certificate of Red Hat Insights or of its upstream proxy
+	ConnectivityTLSError ConnectivityResult = "tls_error"
+)
+
+// connectivityResults lists every result, so that metrics for those
+// not observed can be reset
+var connectivityResults = []ConnectivityResult{
+	ConnectivityOK,
+	ConnectivityUnauthorized,
+	ConnectivityForbiddenPrefix,
+	ConnectivityUnreachable,
+	ConnectivityTLSError,
+}
+
+// reconcileConnectivity checks periodically that requests sent through the proxy are accepted by
+// Red Hat Insights, once the proxy is available. Returns how long until the next check is due,
+// or zero if checks are disabled.
+func (r *InsightsReconciler) reconcileConnectivity(ctx context.Context, status *insightsStatus) time.Duration {
+	interval := r.ConnectivityCheckInterval
+	if interval < 0 {
+		return 0
+	}
+	if interval == 0 {
+		interval = DefaultConnectivityCheckInterval
+	}
+
+	available := meta.FindStatusCondition(status.conditions, v1alpha1.ConditionTypeProxyAvailable)
+	if available == nil || available.Status != metav1.ConditionTrue {
+		// Check as soon as the proxy becomes available
+		r.lastConnectivityCheck = time.Time{}
+		status.setCondition(v1alpha1.ConditionTypeBackendConnected, metav1.ConditionUnknown,
+			v1alpha1.ReasonConnectivityCheckPending, "Waiting for the Insights proxy to become available")
+		return interval
+	}
+	if since := time.Since(r.lastConnectivityCheck); since < interval {
+		// The condition from the last check is kept
+		return interval - since
+	}
+
+	caBundle, err := r.getProxyCABundle(ctx)
+	if err != nil || len(caBundle) == 0 {
+		// OpenShift injects the service CA bundle asynchronously
+		status.setCondition(v1alpha1.ConditionTypeBackendConnected, metav1.ConditionUnknown,
+			v1alpha1.ReasonConnectivityCheckPending, "Waiting for the CA bundle of the Insights proxy")
+		return interval
+	}
+
+	proxyURL := GetProxyURL(r.names.Service, r.Namespace, r.clusterDomain, r.ports.HTTPS)
+	result, message := r.checkConnectivity(ctx, proxyURL.String()+connectivityCheckPath, caBundle)
+	r.lastConnectivityCheck = time.Now()
+	recordConnectivityResult(r.names.Instance, result)
+	if result == ConnectivityOK {
+		status.setCondition(v1alpha1.ConditionTypeBackendConnected, metav1.ConditionTrue,
+			v1alpha1.ReasonBackendAccepted, message)
+	} else {
+		r.Log.Info("Insights connectivity check failed", "result", result, "message", message)
+		status.setCondition(v1alpha1.ConditionTypeBackendConnected, metav1.ConditionFalse,
+			reasonForConnectivityResult(result), message)
+	}
+	return interval
+}
+
+// getProxyCABundle returns the PEM-encoded CA bundle used to verify the proxy,
+// or nil if it is not yet available
+func (r *InsightsReconciler) getProxyCABundle(ctx context.Context) ([]byte, error) {
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.CABundleConfigMap,
+		Namespace: r.Namespace}, cm)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return []byte(cm.Data[common.ProxyCABundleKey]), nil
+}
+
+// checkConnectivity sends an empty upload to the provided URL of the proxy,
+// and classifies the response along with a message describing it
+func (r *InsightsReconciler) checkConnectivity(ctx context.Context, url string, caBundle []byte) (ConnectivityResult, string) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return ConnectivityTLSError, "The CA bundle of the Insights proxy contains no certificates"
+	}
+	dialContext := r.dialContext
+	if dialContext == nil {
+		dialContext = (&net.Dialer{Timeout: connectivityCheckTimeout}).DialContext
+	}
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			// The proxy is reached directly, never through the cluster-wide proxy
+			Proxy:             nil,
+			DialContext:       dialContext,
+			TLSClientConfig:   &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			DisableKeepAlives: true,
+		},
+		Timeout: connectivityCheckTimeout,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, http.NoBody)
+	if err != nil {
+		return ConnectivityUnreachable, err.Error()
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if proxy.IsTLSError(err) {
+			return ConnectivityTLSError, fmt.Sprintf("Failed to verify the Insights proxy: %s", err.Error())
+		}
+		return ConnectivityUnreachable, fmt.Sprintf("Failed to reach the Insights proxy: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	return classifyConnectivityResponse(resp.StatusCode, resp.Header.Get(proxy.ErrorHeader))
+}
+
+// classifyConnectivityResponse classifies the status of the response to an empty upload, along
+// with the failure reported by the built-in proxy, if any. Once authenticated, Red Hat Insights
+// rejects the upload itself as a bad request, too large, or of an unsupported media type. Any
+// other status, such as a 404 from a misrouted backend or a 407 from an upstream proxy, means
+// the request did not reach Red Hat Insights. Red Hat Insights never accepts an empty upload,
+// so a successful status is unexpected as well.
+func classifyConnectivityResponse(statusCode int, proxyError string) (ConnectivityResult, string) {
+	switch {
+	case statusCode == http.StatusBadGateway && proxyError == proxy.ErrorTLS:
+		return ConnectivityTLSError, "The Insights proxy failed to verify the certificate of Red Hat Insights, " +
+			"or of its upstream proxy, which may require a trusted CA bundle"
+	case statusCode == http.StatusUnauthorized:
+		return ConnectivityUnauthorized, "Red Hat Insights rejected the token used by the Insights proxy"
+	case statusCode == http.StatusForbidden:
+		return ConnectivityForbiddenPrefix, "Red Hat Insights refused the User-Agent of the Insights proxy, " +
+			"its prefix may not be approved"
+	case statusCode == http.StatusBadRequest, statusCode == http.StatusRequestEntityTooLarge,
+		statusCode == http.StatusUnsupportedMediaType:
+		return ConnectivityOK, fmt.Sprintf("Red Hat Insights accepted a request sent through the Insights proxy (HTTP %d)",
+			statusCode)
+	case statusCode >= 200 && statusCode < 300:
+		return ConnectivityUnreachable, fmt.Sprintf("The Insights proxy unexpectedly accepted an empty upload (HTTP %d), "+
+			"it may not forward requests to Red Hat Insights", statusCode)
+	default:
+		return ConnectivityUnreachable, fmt.Sprintf("The Insights proxy failed to reach Red Hat Insights (HTTP %d)",
+			statusCode)
+	}
+}
+
+func reasonForConnectivityResult(result ConnectivityResult) string {
+	switch result {
+	case ConnectivityOK:
+		return v1alpha1.ReasonBackendAccepted
+	case ConnectivityUnauthorized:
+		return v1alpha1.ReasonBackendUnauthorized
+	case ConnectivityForbiddenPrefix:
+		return v1alpha1.ReasonUserAgentForbidden
+	case ConnectivityTLSError:
+		return v1alpha1.ReasonProxyTLSError
+	default:
+		return v1alpha1.ReasonBackendUnreachable
+	}
+}
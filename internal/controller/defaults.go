@@ -0,0 +1,115 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+)
+
+// ProxyDefaults configure the Insights proxy programmatically, in place of environment
+// variables. Each value that is set takes precedence over its environment variable,
+// while an InsightsProxy resource overrides both.
+type ProxyDefaults struct {
+	// Whether the proxy is enabled, in place of INSIGHTS_ENABLED
+	Enabled *bool
+	// Red Hat Insights server host, in place of INSIGHTS_BACKEND_DOMAIN
+	BackendDomain string
+	// APICast images by node architecture, in place of RELATED_IMAGE_INSIGHTS_PROXY
+	// and its variants for other architectures
+	ProxyImages map[string]string
+	// Image of the built-in proxy, in place of RELATED_IMAGE_INSIGHTS_BUILTIN_PROXY
+	BuiltinProxyImage string
+	// Proxy to deploy, in place of INSIGHTS_PROXY_IMPLEMENTATION
+	Implementation v1alpha1.ProxyImplementation
+	// Upstream proxy URL, in place of INSIGHTS_PROXY_URL and INSIGHTS_PROXY_DOMAIN
+	UpstreamProxy string
+}
+
+// IsEnabled returns whether the proxy is enabled by these defaults,
+// or otherwise by the INSIGHTS_ENABLED environment variable
+func (d *ProxyDefaults) IsEnabled(osUtils common.OSUtils) bool {
+	if d.Enabled != nil {
+		return *d.Enabled
+	}
+	return strings.ToLower(osUtils.GetEnv(common.EnvInsightsEnabled)) == "true"
+}
+
+// ProxyPorts are the ports used by the Insights proxy. Zero values use the defaults.
+type ProxyPorts struct {
+	// Port of the proxy's HTTPS endpoint, in its pod and Service. Defaults to 8443.
+	HTTPS int32
+	// Plain HTTP port APICast listens on within its pod, not exposed by the Service. Defaults to 8080.
+	HTTP int32
+	// Port serving health probes, in the proxy's pod and Service. Defaults to 8090.
+	// APICast always uses the default.
+	Management int32
+	// Port serving metrics within the proxy's pod. Defaults to 9421.
+	// APICast always uses the default.
+	Metrics int32
+}
+
+// DefaultProxyPorts are the ports used when none are configured
+var DefaultProxyPorts = ProxyPorts{
+	HTTPS:      common.ProxyServicePort,
+	HTTP:       common.ProxyHTTPPort,
+	Management: common.ProxyManagementPort,
+	Metrics:    common.ProxyMetricsPort,
+}
+
+// WithDefaults returns a copy of these ports, replacing unset ports with their defaults
+func (p ProxyPorts) WithDefaults() ProxyPorts {
+	if p.HTTPS == 0 {
+		p.HTTPS = DefaultProxyPorts.HTTPS
+	}
+	if p.HTTP == 0 {
+		p.HTTP = DefaultProxyPorts.HTTP
+	}
+	if p.Management == 0 {
+		p.Management = DefaultProxyPorts.Management
+	}
+	if p.Metrics == 0 {
+		p.Metrics = DefaultProxyPorts.Metrics
+	}
+	return p
+}
+
+// forImplementation returns the ports the implementation listens on,
+// replacing those it does not allow to be configured
+func (p ProxyPorts) forImplementation(implementation v1alpha1.ProxyImplementation) ProxyPorts {
+	if implementation != v1alpha1.ProxyImplementationBuiltin {
+		p.Management = DefaultProxyPorts.Management
+		p.Metrics = DefaultProxyPorts.Metrics
+	}
+	return p
+}
+
+func (p ProxyPorts) validate() error {
+	names := []string{"HTTPS", "HTTP", "management", "metrics"}
+	used := map[int32]string{}
+	for idx, port := range []int32{p.HTTPS, p.HTTP, p.Management, p.Metrics} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid %s port %d for the Insights proxy", names[idx], port)
+		}
+		if other, pres := used[port]; pres {
+			return fmt.Errorf("%s and %s ports of the Insights proxy must differ", other, names[idx])
+		}
+		used[port] = names[idx]
+	}
+	return nil
+}
@@ -0,0 +1,557 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Checks performed by Diagnose, in the order they are reported
+const (
+	CheckOperatorSettings = "OperatorSettings"
+	CheckPullSecret       = "PullSecret"
+	CheckClusterVersion   = "ClusterVersion"
+	CheckRBAC             = "RBAC"
+	CheckConfigMap        = "ConfigMap"
+	CheckProxyDeployment  = "ProxyDeployment"
+	CheckProxyConfig      = "ProxyConfig"
+)
+
+// DiagnosticStatus is the outcome of a check performed by Diagnose
+type DiagnosticStatus string
+
+const (
+	DiagnosticPass DiagnosticStatus = "Pass"
+	DiagnosticFail DiagnosticStatus = "Fail"
+	// DiagnosticSkip is reported when a check does not apply,
+	// or depends on a check that failed
+	DiagnosticSkip DiagnosticStatus = "Skip"
+)
+
+// DiagnosticResult is the outcome of a check performed by Diagnose
+type DiagnosticResult struct {
+	Check   string           `json:"check"`
+	Status  DiagnosticStatus `json:"status"`
+	Details string           `json:"details"`
+}
+
+// DiagnoseConfig contains configuration for Diagnose
+type DiagnoseConfig struct {
+	// Client for the cluster, whose scheme includes the OpenShift config API and InsightsProxy
+	Client client.Client
+	// Namespace of the operator
+	Namespace string
+	// Name of the operator's Deployment
+	OperatorName string
+	// Optional name of the operator's container within its Deployment, defaults to the first container
+	ContainerName string
+	// Manifests containing the ClusterRole and Role required by the operator,
+	// normally config/rbac/role.yaml
+	Role []byte
+}
+
+// errSkipped is returned by checks that do not apply
+type errSkipped struct {
+	reason string
+}
+
+func (e *errSkipped) Error() string {
+	return e.reason
+}
+
+// errDependency skips checks depending on an earlier check that failed
+var errDependency = &errSkipped{reason: "depends on a check that failed"}
+
+type diagnostics struct {
+	*DiagnoseConfig
+	names *common.ResourceNames
+	// Namespace of the proxy, which differs from that of the operator if it is shared
+	proxyNamespace string
+	// Set by checkOperatorSettings
+	operator   *appsv1.Deployment
+	env        map[string]string
+	reconciler *InsightsReconciler
+	config     *proxyConfig
+	proxy      *v1alpha1.InsightsProxy
+	// Set by checkClusterVersion
+	clusterID string
+	results   []DiagnosticResult
+}
+
+// Diagnose checks the preconditions of the InsightsReconciler for the operator's Deployment,
+// using the environment variables set on it, and whether its proxy is deployed as expected
+func Diagnose(ctx context.Context, config *DiagnoseConfig) []DiagnosticResult {
+	d := &diagnostics{
+		DiagnoseConfig: config,
+		names:          common.NewResourceNames(""),
+		proxyNamespace: config.Namespace,
+		env:            map[string]string{},
+	}
+	for _, check := range []struct {
+		name string
+		run  func(context.Context) (string, error)
+	}{
+		{CheckOperatorSettings, d.checkOperatorSettings},
+		{CheckPullSecret, d.checkPullSecret},
+		{CheckClusterVersion, d.checkClusterVersion},
+		{CheckRBAC, d.checkRBAC},
+		{CheckConfigMap, d.checkConfigMap},
+		{CheckProxyDeployment, d.checkProxyDeployment},
+		{CheckProxyConfig, d.checkProxyConfig},
+	} {
+		details, err := check.run(ctx)
+		result := DiagnosticResult{Check: check.name, Status: DiagnosticPass, Details: details}
+		var skipped *errSkipped
+		if errors.As(err, &skipped) {
+			result.Status = DiagnosticSkip
+			result.Details = err.Error()
+		} else if err != nil {
+			result.Status = DiagnosticFail
+			result.Details = err.Error()
+		}
+		d.results = append(d.results, result)
+	}
+	return d.results
+}
+
+// DiagnosticsPassed returns whether none of the checks failed
+func DiagnosticsPassed(results []DiagnosticResult) bool {
+	for _, result := range results {
+		if result.Status == DiagnosticFail {
+			return false
+		}
+	}
+	return true
+}
+
+// checkOperatorSettings validates the Insights settings on the operator's Deployment,
+// along with any InsightsProxy, the same way as the InsightsReconciler
+func (d *diagnostics) checkOperatorSettings(ctx context.Context) (string, error) {
+	deploy := &appsv1.Deployment{}
+	err := d.Client.Get(ctx, types.NamespacedName{Name: d.OperatorName, Namespace: d.Namespace}, deploy)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the operator's Deployment: %w", err)
+	}
+	d.operator = deploy
+	container, err := d.getOperatorContainer()
+	if err != nil {
+		return "", err
+	}
+	d.env = getContainerEnv(deploy.Namespace, container)
+	var settings []string
+	for _, env := range container.Env {
+		if env.ValueFrom != nil {
+			continue
+		}
+		if strings.HasPrefix(env.Name, "INSIGHTS_") || strings.HasPrefix(env.Name, common.EnvInsightsProxyImageTag) ||
+			strings.HasPrefix(env.Name, common.EnvInsightsBuiltinProxyImageTag) {
+			settings = append(settings, env.Name+"="+env.Value)
+		}
+	}
+	sort.Strings(settings)
+
+	userAgentPrefix := d.env["USER_AGENT_PREFIX"]
+	if len(userAgentPrefix) == 0 {
+		return "", errors.New("USER_AGENT_PREFIX is not set")
+	}
+	var shared *SharedProxyConfig
+	if namespace := d.env[common.EnvInsightsSharedProxyNamespace]; len(namespace) > 0 {
+		d.proxyNamespace = namespace
+		shared = &SharedProxyConfig{OperatorNamespace: d.Namespace}
+	}
+	r, err := NewInsightsReconciler(&InsightsReconcilerConfig{
+		Client:          d.Client,
+		Log:             logr.Discard(),
+		Scheme:          d.Client.Scheme(),
+		Namespace:       d.proxyNamespace,
+		UserAgentPrefix: userAgentPrefix,
+		OperatorName:    d.OperatorName,
+		Shared:          shared,
+		OSUtils:         renderEnv(d.env),
+	})
+	if err != nil {
+		return "", err
+	}
+	proxy, err := r.getInsightsProxy(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the InsightsProxy: %w", err)
+	}
+	config, err := r.getProxyConfig(proxy)
+	if err != nil {
+		return "", err
+	}
+	if !config.enabled {
+		return "", fmt.Errorf("the Insights proxy is disabled by %s or the InsightsProxy", common.EnvInsightsEnabled)
+	}
+	if len(config.imageOverride) == 0 && len(r.builtinProxyImageTag) == 0 && len(r.proxyImageTags) == 0 {
+		return "", fmt.Errorf("no Insights proxy image is set, %s is required", common.EnvInsightsProxyImageTag)
+	}
+	d.reconciler = r
+	d.config = config
+	d.proxy = proxy
+
+	details := strings.Join(settings, ", ")
+	if proxy != nil {
+		details += fmt.Sprintf(", overridden by InsightsProxy %s", proxy.Name)
+	}
+	return details, nil
+}
+
+func (d *diagnostics) getOperatorContainer() (*corev1.Container, error) {
+	containers := d.operator.Spec.Template.Spec.Containers
+	for i, container := range containers {
+		if container.Name == d.ContainerName || (len(d.ContainerName) == 0 && i == 0) {
+			return &containers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no container %q in the operator's Deployment", d.ContainerName)
+}
+
+// getContainerEnv returns the environment variables of the operator's container with literal values.
+// Only the operator's namespace is resolved from references.
+func getContainerEnv(namespace string, container *corev1.Container) map[string]string {
+	result := map[string]string{}
+	for _, env := range container.Env {
+		if env.ValueFrom != nil {
+			if env.ValueFrom.FieldRef != nil && env.ValueFrom.FieldRef.FieldPath == "metadata.namespace" {
+				result[env.Name] = namespace
+			}
+			continue
+		}
+		result[env.Name] = env.Value
+	}
+	return result
+}
+
+// checkPullSecret reads the token used to authenticate with Red Hat Insights, normally
+// the cloud.openshift.com auth within the global pull secret
+func (d *diagnostics) checkPullSecret(ctx context.Context) (string, error) {
+	if d.reconciler == nil {
+		return "", errDependency
+	}
+	_, source, err := d.reconciler.getToken(ctx, d.config)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("found a token in %s", source), nil
+}
+
+// checkClusterVersion reads the cluster ID, normally from the OpenShift ClusterVersion
+func (d *diagnostics) checkClusterVersion(ctx context.Context) (string, error) {
+	if d.reconciler == nil {
+		return "", errDependency
+	}
+	clusterID, err := d.reconciler.getClusterID(ctx, d.config)
+	if err != nil {
+		return "", err
+	}
+	if len(clusterID) == 0 {
+		return "", errors.New("the cluster ID is empty")
+	}
+	d.clusterID = clusterID
+	return fmt.Sprintf("cluster ID %s", clusterID), nil
+}
+
+// checkRBAC reviews whether the operator's service account is granted each
+// permission of the ClusterRole and Role within the RBAC manifests. The rules of the
+// Role are needed in the operator's namespace, and in that of a shared proxy.
+func (d *diagnostics) checkRBAC(ctx context.Context) (string, error) {
+	if d.operator == nil {
+		return "", errDependency
+	}
+	serviceAccount := d.operator.Spec.Template.Spec.ServiceAccountName
+	if len(serviceAccount) == 0 {
+		serviceAccount = "default"
+	}
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", d.Namespace, serviceAccount)
+	groups := []string{"system:serviceaccounts", "system:serviceaccounts:" + d.Namespace, "system:authenticated"}
+
+	roles, err := parseRoles(d.Role)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse RBAC manifests: %w", err)
+	}
+	roleNamespaces := []string{d.Namespace}
+	if d.proxyNamespace != d.Namespace {
+		roleNamespaces = append(roleNamespaces, d.proxyNamespace)
+	}
+	var denied []string
+	reviewed := 0
+	for _, role := range roles {
+		var attrsList []*authorizationv1.ResourceAttributes
+		if role.Kind == "Role" {
+			for _, namespace := range roleNamespaces {
+				attrsList = append(attrsList, getResourceAttributes(namespace, role.Rules)...)
+			}
+		} else {
+			attrsList = getResourceAttributes("", role.Rules)
+		}
+		for _, attrs := range attrsList {
+			sar := &authorizationv1.SubjectAccessReview{
+				Spec: authorizationv1.SubjectAccessReviewSpec{
+					User:               user,
+					Groups:             groups,
+					ResourceAttributes: attrs,
+				},
+			}
+			err := d.Client.Create(ctx, sar)
+			if err != nil {
+				return "", fmt.Errorf("unable to review access: %w", err)
+			}
+			reviewed++
+			if !sar.Status.Allowed {
+				denied = append(denied, formatResourceAttributes(attrs))
+			}
+		}
+	}
+	if len(denied) > 0 {
+		return "", fmt.Errorf("%s is denied: %s", user, strings.Join(denied, ", "))
+	}
+	return fmt.Sprintf("%s is granted all %d permissions", user, reviewed), nil
+}
+
+// parseRoles decodes the ClusterRoles and Roles within a stream of YAML manifests
+func parseRoles(manifests []byte) ([]rbacv1.Role, error) {
+	var roles []rbacv1.Role
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifests), 4096)
+	for {
+		role := rbacv1.Role{}
+		err := decoder.Decode(&role)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if role.Kind == "ClusterRole" || role.Kind == "Role" {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("no ClusterRole or Role found")
+	}
+	return roles, nil
+}
+
+// getResourceAttributes expands policy rules into one request per group, resource, name and verb
+func getResourceAttributes(namespace string, rules []rbacv1.PolicyRule) []*authorizationv1.ResourceAttributes {
+	var result []*authorizationv1.ResourceAttributes
+	for _, rule := range rules {
+		names := rule.ResourceNames
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				resource, subresource, _ := strings.Cut(resource, "/")
+				for _, name := range names {
+					for _, verb := range rule.Verbs {
+						result = append(result, &authorizationv1.ResourceAttributes{
+							Namespace:   namespace,
+							Verb:        verb,
+							Group:       group,
+							Resource:    resource,
+							Subresource: subresource,
+							Name:        name,
+						})
+					}
+				}
+			}
+		}
+	}
+	return result
+}
+
+func formatResourceAttributes(attrs *authorizationv1.ResourceAttributes) string {
+	resource := attrs.Resource
+	if len(attrs.Group) > 0 {
+		resource += "." + attrs.Group
+	}
+	if len(attrs.Subresource) > 0 {
+		resource += "/" + attrs.Subresource
+	}
+	if len(attrs.Name) > 0 {
+		resource += " " + attrs.Name
+	}
+	if len(attrs.Namespace) > 0 {
+		resource += " in " + attrs.Namespace
+	}
+	return attrs.Verb + " " + resource
+}
+
+// checkConfigMap checks that the Insights config map exists and is owned by the operator's
+// Deployment, so that the proxy is deleted along with the operator
+func (d *diagnostics) checkConfigMap(ctx context.Context) (string, error) {
+	cm := &corev1.ConfigMap{}
+	err := d.Client.Get(ctx, types.NamespacedName{Name: d.names.ConfigMap, Namespace: d.proxyNamespace}, cm)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return "", fmt.Errorf("config map %s not found, it is created when the operator starts", d.names.ConfigMap)
+		}
+		return "", err
+	}
+	owner := metav1.GetControllerOf(cm)
+	// A shared proxy outlives the operator that created it
+	if d.proxyNamespace != d.Namespace {
+		if owner != nil {
+			return "", fmt.Errorf("shared config map %s is owned by %s %s", cm.Name, owner.Kind, owner.Name)
+		}
+		return fmt.Sprintf("found shared config map %s", cm.Name), nil
+	}
+	if owner == nil || owner.Kind != "Deployment" || owner.Name != d.OperatorName {
+		return "", fmt.Errorf("config map %s is not owned by the operator's Deployment %s", cm.Name, d.OperatorName)
+	}
+	return fmt.Sprintf("found config map %s owned by Deployment %s", cm.Name, owner.Name), nil
+}
+
+// checkProxyDeployment checks that the proxy's Deployment is rolled out and ready
+func (d *diagnostics) checkProxyDeployment(ctx context.Context) (string, error) {
+	deploy := &appsv1.Deployment{}
+	err := d.Client.Get(ctx, types.NamespacedName{Name: d.names.Deployment, Namespace: d.proxyNamespace}, deploy)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return "", fmt.Errorf("deployment %s not found", d.names.Deployment)
+		}
+		return "", err
+	}
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	status := deploy.Status
+	details := fmt.Sprintf("%d of %d replicas ready", status.ReadyReplicas, replicas)
+	if status.ObservedGeneration < deploy.Generation || status.UpdatedReplicas < replicas {
+		return "", fmt.Errorf("deployment %s is rolling out, %s", deploy.Name, details)
+	}
+	if status.ReadyReplicas < replicas || status.AvailableReplicas < replicas {
+		return "", fmt.Errorf("deployment %s is not ready, %s", deploy.Name, details)
+	}
+	return details, nil
+}
+
+// checkProxyConfig compares the proxy's configuration with that rendered from the current settings
+func (d *diagnostics) checkProxyConfig(ctx context.Context) (string, error) {
+	if d.reconciler == nil || len(d.clusterID) == 0 {
+		return "", errDependency
+	}
+	if d.proxyNamespace != d.Namespace {
+		return "", &errSkipped{reason: "the User-Agent of a shared proxy depends on each operator sharing it"}
+	}
+	if _, ok := d.config.tokenSource.(ExpiringTokenSource); ok {
+		return "", &errSkipped{reason: "the token expires, and changes when it is refreshed"}
+	}
+	secret := &corev1.Secret{}
+	err := d.Client.Get(ctx, types.NamespacedName{Name: d.names.ProxySecret, Namespace: d.proxyNamespace}, secret)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return "", fmt.Errorf("secret %s not found", d.names.ProxySecret)
+		}
+		return "", err
+	}
+
+	objs, err := d.getRenderObjects(ctx)
+	if err != nil {
+		return "", err
+	}
+	// The pull secret is provided separately, and was read by checkPullSecret if it is used
+	pullSecret := &corev1.Secret{}
+	err = d.Client.Get(ctx, d.reconciler.pullSecret.GetSecret(), pullSecret)
+	if err != nil && !kerrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return "", err
+	}
+	rendered, err := Render(ctx, &RenderConfig{
+		Namespace:       d.proxyNamespace,
+		OperatorName:    d.OperatorName,
+		UserAgentPrefix: d.reconciler.UserAgentPrefix,
+		PullSecret:      pullSecret.Data[corev1.DockerConfigJsonKey],
+		ClusterID:       d.clusterID,
+		Env:             d.env,
+		Objects:         objs,
+		ShowToken:       true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to render the proxy's configuration: %w", err)
+	}
+	for key, value := range rendered[0].(*corev1.Secret).Data {
+		existing := secret.Data[key]
+		// The built-in proxy's configuration is compared exactly
+		if !bytes.Equal(existing, value) && !isProxyConfigEqual(v1alpha1.ProxyImplementationAPICast, existing, value) {
+			return "", fmt.Errorf("%s in secret %s differs from the configuration rendered from the current settings",
+				key, secret.Name)
+		}
+	}
+	return fmt.Sprintf("secret %s matches the current settings", secret.Name), nil
+}
+
+// getRenderObjects returns the objects read by the InsightsReconciler
+// when generating the proxy's configuration
+func (d *diagnostics) getRenderObjects(ctx context.Context) ([]client.Object, error) {
+	var objs []client.Object
+	if d.proxy != nil {
+		objs = append(objs, d.proxy)
+	}
+	tokenSource, ok := d.config.tokenSource.(SecretTokenSource)
+	if _, pullSecret := tokenSource.(*PullSecretTokenSource); ok && !pullSecret {
+		objs = append(objs, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      tokenSource.GetSecret().Name,
+			Namespace: tokenSource.GetSecret().Namespace,
+		}})
+	}
+	if len(d.config.proxyCredentialsSecret) > 0 {
+		objs = append(objs, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      d.config.proxyCredentialsSecret,
+			Namespace: d.proxyNamespace,
+		}})
+	}
+	objs = append(objs, &configv1.Proxy{ObjectMeta: metav1.ObjectMeta{Name: clusterProxyName}})
+
+	result := make([]client.Object, 0, len(objs))
+	for _, obj := range objs {
+		if obj == d.proxy {
+			result = append(result, obj)
+			continue
+		}
+		err := d.Client.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		if err != nil {
+			// Any missing objects are reported when rendering
+			if kerrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, err
+		}
+		result = append(result, obj)
+	}
+	return result, nil
+}
@@ -0,0 +1,45 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Reasons for events emitted by the Insights controller. Warning events
+// for reconcile failures use the reasons from the v1alpha1 API package.
+const (
+	EventReasonProxyCreated  = "ProxyCreated"
+	EventReasonConfigRotated = "ConfigRotated"
+	EventReasonProxyDeleted  = "ProxyDeleted"
+)
+
+// EventRecorderName is the component name the Insights controller emits events as
+const EventRecorderName = "insights-controller"
+
+func (r *InsightsReconciler) recordEvent(objs []runtime.Object, eventType string, reason string, message string) {
+	// Events are optional when not provided a recorder
+	if r.Recorder == nil {
+		return
+	}
+	for _, obj := range objs {
+		r.Recorder.Event(obj, eventType, reason, message)
+	}
+}
+
+func (r *InsightsReconciler) recordWarning(obj runtime.Object, err error) {
+	r.recordEvent([]runtime.Object{obj}, corev1.EventTypeWarning, reasonForError(err), err.Error())
+}
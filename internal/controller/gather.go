@@ -0,0 +1,458 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// GatherDir is the directory within the archive written by Gather
+const GatherDir = "insights"
+
+// GatherConfig contains configuration for Gather
+type GatherConfig struct {
+	// Client for the cluster, whose scheme includes InsightsProxy. Only used to read objects.
+	Client client.Client
+	// Used to read container logs
+	Pods corev1client.PodsGetter
+	// Namespace of the operator
+	Namespace string
+	// Name of the operator's Deployment
+	OperatorName string
+	// Optional name of the instance of the integration, see InsightsReconcilerConfig.Instance
+	Instance string
+	// Optional namespace of the proxy if it is shared, defaults to Namespace
+	ProxyNamespace string
+	// Optional source of the token, see InsightsReconcilerConfig.TokenSource
+	TokenSource TokenSource
+}
+
+// Tokens in HTTP headers, the built-in proxy's configuration and pull secrets
+var sensitivePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/=]+`),
+	regexp.MustCompile(`("(?:auth|token)"\s*:\s*")[^"]*`),
+}
+
+type gatherer struct {
+	*GatherConfig
+	scheme *runtime.Scheme
+	names  *common.ResourceNames
+	tw     *tar.Writer
+	// Values removed from everything collected
+	secrets []string
+	// Problems collecting individual items, which are reported within the archive
+	problems []string
+	// Objects whose events are collected, by kind
+	involved map[string]map[types.NamespacedName]bool
+}
+
+// Gather writes a gzip-compressed tar archive containing the objects managed by the InsightsReconciler,
+// their events, and the logs of the proxy and operator, for use in support cases. Secrets are not collected,
+// and any token or pull secret contents found in the collected data are redacted. Items that cannot be
+// collected are listed in errors.txt within the archive, rather than failing.
+func Gather(ctx context.Context, config *GatherConfig, w io.Writer) error {
+	proxyNamespace := config.ProxyNamespace
+	if len(proxyNamespace) == 0 {
+		proxyNamespace = config.Namespace
+	}
+	s := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		appsv1.AddToScheme, corev1.AddToScheme, v1alpha1.AddToScheme,
+	} {
+		if err := addToScheme(s); err != nil {
+			return err
+		}
+	}
+	gz := gzip.NewWriter(w)
+	g := &gatherer{
+		GatherConfig: config,
+		scheme:       s,
+		names:        common.NewResourceNames(config.Instance),
+		tw:           tar.NewWriter(gz),
+		involved:     map[string]map[types.NamespacedName]bool{},
+	}
+	g.readSecrets(ctx, proxyNamespace)
+
+	// The proxy and its configuration
+	for _, obj := range []client.Object{
+		&v1alpha1.InsightsProxy{ObjectMeta: metav1.ObjectMeta{Name: g.names.InsightsProxy, Namespace: proxyNamespace}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: g.names.ConfigMap, Namespace: proxyNamespace}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: g.names.Service, Namespace: proxyNamespace}},
+	} {
+		if _, err := g.gatherObject(ctx, obj); err != nil {
+			return err
+		}
+	}
+	proxyDeploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: g.names.Deployment, Namespace: proxyNamespace}}
+	found, err := g.gatherObject(ctx, proxyDeploy)
+	if err != nil {
+		return err
+	}
+	if found {
+		if err := g.gatherWorkload(ctx, proxyDeploy); err != nil {
+			return err
+		}
+	}
+
+	// The operator, including its logs
+	operator := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: config.OperatorName, Namespace: config.Namespace}}
+	found, err = g.gatherObject(ctx, operator)
+	if err != nil {
+		return err
+	}
+	if found {
+		if err := g.gatherWorkload(ctx, operator); err != nil {
+			return err
+		}
+	}
+
+	if err := g.gatherEvents(ctx, proxyNamespace); err != nil {
+		return err
+	}
+	if len(g.problems) > 0 {
+		err := g.writeFile("errors.txt", []byte(strings.Join(g.problems, "\n")+"\n"))
+		if err != nil {
+			return err
+		}
+	}
+	if err := g.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readSecrets reads the tokens to redact, if this is permitted. The token is resolved the same way as
+// by the InsightsReconciler, using the settings of the operator's Deployment, and the contents of the
+// pull secret and the proxy's configuration are also redacted.
+func (g *gatherer) readSecrets(ctx context.Context, proxyNamespace string) {
+	env := map[string]string{}
+	operator := &appsv1.Deployment{}
+	err := g.Client.Get(ctx, types.NamespacedName{Name: g.OperatorName, Namespace: g.Namespace}, operator)
+	if err == nil {
+		// The settings may be on any of the operator's containers
+		for idx := range operator.Spec.Template.Spec.Containers {
+			for name, value := range getContainerEnv(operator.Namespace, &operator.Spec.Template.Spec.Containers[idx]) {
+				if _, pres := env[name]; !pres {
+					env[name] = value
+				}
+			}
+		}
+	}
+	r, err := NewInsightsReconciler(&InsightsReconcilerConfig{
+		Client:       g.Client,
+		Log:          logr.Discard(),
+		Scheme:       g.scheme,
+		Namespace:    proxyNamespace,
+		OperatorName: g.OperatorName,
+		Instance:     g.Instance,
+		TokenSource:  g.TokenSource,
+		OSUtils:      renderEnv(env),
+	})
+	if err != nil {
+		g.addProblem("unable to resolve the token to redact: %v", err)
+		return
+	}
+	if err := g.readToken(ctx, r); err != nil {
+		g.addProblem("unable to read the token to redact: %v", err)
+	}
+
+	pullSecret := &corev1.Secret{}
+	err = g.Client.Get(ctx, r.pullSecret.GetSecret(), pullSecret)
+	if err == nil {
+		dockerConfig := struct {
+			Auths map[string]struct {
+				Auth string `json:"auth"`
+			} `json:"auths"`
+		}{}
+		if json.Unmarshal(pullSecret.Data[corev1.DockerConfigJsonKey], &dockerConfig) == nil {
+			for _, auth := range dockerConfig.Auths {
+				g.addSecret(auth.Auth)
+			}
+		}
+	}
+	proxySecret := &corev1.Secret{}
+	err = g.Client.Get(ctx, types.NamespacedName{Name: g.names.ProxySecret, Namespace: proxyNamespace}, proxySecret)
+	if err == nil {
+		config := string(proxySecret.Data["config.json"])
+		for _, pattern := range sensitivePatterns {
+			for _, match := range pattern.FindAllStringSubmatch(config, -1) {
+				g.addSecret(strings.TrimPrefix(match[0], match[1]))
+			}
+		}
+	}
+}
+
+// readToken adds the token the InsightsReconciler authenticates with to the values redacted.
+// Tokens that expire are obtained from credentials in a Secret, which are redacted instead.
+func (g *gatherer) readToken(ctx context.Context, r *InsightsReconciler) error {
+	proxy, err := r.getInsightsProxy(ctx)
+	if err != nil {
+		return err
+	}
+	config, err := r.getProxyConfig(proxy)
+	if err != nil {
+		return err
+	}
+	source, err := r.getTokenSource(config)
+	if err != nil {
+		// No token is configured
+		return nil
+	}
+	if _, ok := source.(ExpiringTokenSource); ok {
+		secretSource, ok := source.(SecretTokenSource)
+		if !ok {
+			return nil
+		}
+		secret := &corev1.Secret{}
+		err := g.Client.Get(ctx, secretSource.GetSecret(), secret)
+		if err != nil {
+			return err
+		}
+		for _, value := range secret.Data {
+			g.addSecret(string(value))
+		}
+		return nil
+	}
+	token, err := source.GetToken(ctx, g.Client)
+	if err != nil {
+		return err
+	}
+	g.addSecret(token)
+	return nil
+}
+
+func (g *gatherer) addSecret(value string) {
+	if len(value) > 0 {
+		g.secrets = append(g.secrets, value)
+	}
+}
+
+// scrub redacts tokens within the collected data
+func (g *gatherer) scrub(data []byte) []byte {
+	result := string(data)
+	for _, secret := range g.secrets {
+		result = strings.ReplaceAll(result, secret, RedactedToken)
+	}
+	for _, pattern := range sensitivePatterns {
+		result = pattern.ReplaceAllString(result, "${1}"+RedactedToken)
+	}
+	return []byte(result)
+}
+
+// gatherObject writes the object if it exists, returning whether it was found
+func (g *gatherer) gatherObject(ctx context.Context, obj client.Object) (bool, error) {
+	err := g.Client.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	if err != nil {
+		if !kerrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			g.addProblem("unable to read %T %s/%s: %v", obj, obj.GetNamespace(), obj.GetName(), err)
+		}
+		return false, nil
+	}
+	return true, g.writeObject(obj)
+}
+
+// gatherWorkload writes the ReplicaSets and pods of the Deployment, along with the logs of the pods
+func (g *gatherer) gatherWorkload(ctx context.Context, deploy *appsv1.Deployment) error {
+	selector, err := metav1.LabelSelectorAsSelector(deploy.Spec.Selector)
+	if err != nil {
+		g.addProblem("invalid selector for Deployment %s/%s: %v", deploy.Namespace, deploy.Name, err)
+		return nil
+	}
+	opts := []client.ListOption{client.InNamespace(deploy.Namespace), client.MatchingLabelsSelector{Selector: selector}}
+	replicaSets := &appsv1.ReplicaSetList{}
+	err = g.Client.List(ctx, replicaSets, opts...)
+	if err != nil {
+		g.addProblem("unable to list ReplicaSets in %s: %v", deploy.Namespace, err)
+		return nil
+	}
+	var owners []*appsv1.ReplicaSet
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if metav1.IsControlledBy(rs, deploy) {
+			owners = append(owners, rs)
+			if err := g.writeObject(rs); err != nil {
+				return err
+			}
+		}
+	}
+
+	pods := &corev1.PodList{}
+	err = g.Client.List(ctx, pods, opts...)
+	if err != nil {
+		g.addProblem("unable to list pods in %s: %v", deploy.Namespace, err)
+		return nil
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		for _, rs := range owners {
+			if !metav1.IsControlledBy(pod, rs) {
+				continue
+			}
+			if err := g.writeObject(pod); err != nil {
+				return err
+			}
+			if err := g.gatherLogs(ctx, pod); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gatherLogs writes the logs of each container in the pod, including those of the
+// previous instance of any container that restarted
+func (g *gatherer) gatherLogs(ctx context.Context, pod *corev1.Pod) error {
+	restarts := map[string]int32{}
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts[status.Name] = status.RestartCount
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, previous := range []bool{false, true} {
+			if previous && restarts[container.Name] == 0 {
+				continue
+			}
+			logs, err := g.Pods.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+				Container: container.Name,
+				Previous:  previous,
+			}).DoRaw(ctx)
+			if err != nil {
+				g.addProblem("unable to read logs of container %s in pod %s/%s: %v", container.Name, pod.Namespace,
+					pod.Name, err)
+				continue
+			}
+			name := container.Name + ".log"
+			if previous {
+				name = container.Name + ".previous.log"
+			}
+			if err := g.writeFile(path.Join(pod.Namespace, "logs", pod.Name, name), logs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gatherEvents writes the events involving collected objects, sorted by time
+func (g *gatherer) gatherEvents(ctx context.Context, proxyNamespace string) error {
+	namespaces := []string{proxyNamespace}
+	if g.Namespace != proxyNamespace {
+		namespaces = append(namespaces, g.Namespace)
+	}
+	for _, namespace := range namespaces {
+		events := &corev1.EventList{}
+		err := g.Client.List(ctx, events, client.InNamespace(namespace))
+		if err != nil {
+			g.addProblem("unable to list events in %s: %v", namespace, err)
+			continue
+		}
+		var involved []corev1.Event
+		for _, event := range events.Items {
+			ref := event.InvolvedObject
+			if g.involved[ref.Kind][types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}] {
+				involved = append(involved, event)
+			}
+		}
+		if len(involved) == 0 {
+			continue
+		}
+		sort.SliceStable(involved, func(i, j int) bool {
+			return getEventTime(&involved[i]).Before(getEventTime(&involved[j]))
+		})
+		events.Items = involved
+		events.ResourceVersion = ""
+		if err := g.writeYAML(path.Join(namespace, "events.yaml"), events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getEventTime(event *corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// writeObject writes the object as YAML at <namespace>/<kind>/<name>.yaml
+func (g *gatherer) writeObject(obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, g.scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetManagedFields(nil)
+	if g.involved[gvk.Kind] == nil {
+		g.involved[gvk.Kind] = map[types.NamespacedName]bool{}
+	}
+	g.involved[gvk.Kind][client.ObjectKeyFromObject(obj)] = true
+	return g.writeYAML(path.Join(obj.GetNamespace(), strings.ToLower(gvk.Kind), obj.GetName()+".yaml"), obj)
+}
+
+func (g *gatherer) writeYAML(name string, obj interface{}) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return g.writeFile(name, data)
+}
+
+// writeFile adds the scrubbed data to the archive
+func (g *gatherer) writeFile(name string, data []byte) error {
+	data = g.scrub(data)
+	err := g.tw.WriteHeader(&tar.Header{
+		Name:    path.Join(GatherDir, name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = g.tw.Write(data)
+	return err
+}
+
+func (g *gatherer) addProblem(format string, args ...interface{}) {
+	g.problems = append(g.problems, fmt.Sprintf(format, args...))
+}
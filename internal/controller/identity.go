@@ -0,0 +1,110 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Namespace whose UID identifies the cluster when it is not OpenShift
+const clusterIDNamespace = "kube-system"
+
+var clusterVersionKind = configv1.GroupVersion.WithKind("ClusterVersion")
+
+// IsOpenShift returns whether the OpenShift config API, providing the global
+// pull secret and ClusterVersion, is available from the provided REST mapper
+func IsOpenShift(mapper meta.RESTMapper) (bool, error) {
+	_, err := mapper.RESTMapping(clusterVersionKind.GroupKind(), clusterVersionKind.Version)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// getToken returns the token used to authenticate with Red Hat Insights,
+// along with the source it was obtained from
+func (r *InsightsReconciler) getToken(ctx context.Context, config *proxyConfig) (string, TokenSource, error) {
+	source, err := r.getTokenSource(config)
+	if err != nil {
+		return "", nil, err
+	}
+	config.tokenSource = source
+	token, err := source.GetToken(ctx, r.Client)
+	if err != nil {
+		// Sources provided by embedding operators may not report a reason
+		var reconcileErr *reconcileError
+		if !errors.As(err, &reconcileErr) {
+			err = newReconcileError(v1alpha1.ReasonTokenMissing, err)
+		}
+		return "", source, err
+	}
+	return token, source, nil
+}
+
+// getTokenSource returns the configured TokenSource, falling back
+// to the global pull secret on OpenShift
+func (r *InsightsReconciler) getTokenSource(config *proxyConfig) (TokenSource, error) {
+	if config.tokenSource != nil {
+		return config.tokenSource, nil
+	}
+	openshift, err := IsOpenShift(r.Client.RESTMapper())
+	if err != nil {
+		return nil, newReconcileError(v1alpha1.ReasonPullSecretUnavailable, err)
+	}
+	if !openshift {
+		return nil, newReconcileError(v1alpha1.ReasonTokenMissing,
+			errors.New("no Secret containing a Red Hat token is configured, and the OpenShift global pull secret is unavailable"))
+	}
+	return r.pullSecret, nil
+}
+
+// getClusterID returns the identifier of the cluster reported to Red Hat Insights.
+// Unless configured explicitly, this is the OpenShift cluster ID,
+// or the UID of the kube-system namespace on other Kubernetes distributions.
+func (r *InsightsReconciler) getClusterID(ctx context.Context, config *proxyConfig) (string, error) {
+	if len(config.clusterID) > 0 {
+		return config.clusterID, nil
+	}
+	openshift, err := IsOpenShift(r.Client.RESTMapper())
+	if err != nil {
+		return "", newReconcileError(v1alpha1.ReasonClusterIDUnavailable, err)
+	}
+	if openshift {
+		cv := &configv1.ClusterVersion{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: "version"}, cv)
+		if err != nil {
+			return "", newReconcileError(v1alpha1.ReasonClusterVersionUnavailable, err)
+		}
+		return string(cv.Spec.ClusterID), nil
+	}
+
+	// The kube-system namespace exists for the lifetime of the cluster
+	ns := &corev1.Namespace{}
+	err = r.getAPIReader().Get(ctx, types.NamespacedName{Name: clusterIDNamespace}, ns)
+	if err != nil {
+		return "", newReconcileError(v1alpha1.ReasonClusterIDUnavailable, err)
+	}
+	return string(ns.UID), nil
+}
@@ -16,180 +16,580 @@ package controller
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"strings"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
 
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
 	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
-	configv1 "github.com/openshift/api/config/v1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/proxy"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-func (r *InsightsReconciler) reconcileInsights(ctx context.Context) error {
-	err := r.reconcilePullSecret(ctx)
+// proxyConfig is the configuration used to deploy the Insights proxy,
+// combining defaults from the environment with any InsightsProxy overrides
+type proxyConfig struct {
+	enabled            bool
+	implementation     v1alpha1.ProxyImplementation
+	backendDomain      string
+	imageOverride      string
+	tokenSource        TokenSource
+	clusterID          string
+	trustedCAConfigMap string
+	resources          *corev1.ResourceRequirements
+	// Upstream proxy explicitly configured, if any, and the
+	// Secret containing its credentials
+	upstreamProxy          *url.URL
+	proxyCredentialsSecret string
+	noProxy                string
+	// Selected based on the cluster's nodes when reconciling
+	proxyImageTag string
+	architectures []string
+	// Whether a trusted CA bundle is available to mount when reconciling
+	trustedCA bool
+	// Pod Security Admission level enforced in the operator's namespace, if any
+	podSecurityLevel string
+	// Operators using a shared proxy, each reported with its own User-Agent prefix
+	sharedMembers []sharedProxyMember
+}
+
+// GetProxyURL returns the URL of the named Insights proxy Service in the provided namespace,
+// for a cluster with the provided DNS domain and a proxy using the provided HTTPS port
+func GetProxyURL(service string, namespace string, clusterDomain string, port int32) *url.URL {
+	return &url.URL{
+		Scheme: "https",
+		Host:   fmt.Sprintf("%s:%d", getProxyServiceFQDN(service, namespace, clusterDomain), port),
+	}
+}
+
+// getProxyServiceFQDN returns the fully qualified domain name of the named Insights proxy Service
+func getProxyServiceFQDN(service string, namespace string, clusterDomain string) string {
+	return fmt.Sprintf("%s.%s.svc.%s", service, namespace, clusterDomain)
+}
+
+func (r *InsightsReconciler) reconcileInsights(ctx context.Context) (reconcile.Result, error) {
+	proxy, err := r.getInsightsProxy(ctx)
 	if err != nil {
-		return err
+		return reconcile.Result{}, err
+	}
+	// Watches reconcile changes to the Secrets it names
+	r.proxySecrets.set(proxy)
+
+	status := &insightsStatus{}
+	result := reconcile.Result{}
+	config, err := r.getProxyConfig(proxy)
+	if err != nil {
+		err = newReconcileError(v1alpha1.ReasonInvalidConfiguration, err)
+	} else if config.enabled && r.Shared != nil {
+		// Only the operator holding the shared proxy's Lease manages the proxy,
+		// while every member renews its own Lease before it expires
+		var owner bool
+		owner, err = r.joinSharedProxy(ctx, config)
+		result.RequeueAfter = SharedProxyLeaseDuration / leaseRenewFraction
+		if err == nil && !owner {
+			// The owner reports the status of the proxy on the shared config map,
+			// and on the InsightsProxy of the shared namespace
+			return result, nil
+		}
+	}
+	if err != nil {
+		status.setResult(err)
+		// Persist the status on the config map, as reconcileProxy does
+		statusErr := r.updateConfigMapStatus(ctx, status)
+		if statusErr != nil {
+			r.Log.Error(statusErr, "failed to update the status of the config map")
+		}
+	} else if config.enabled {
+		var renewAfter time.Duration
+		renewAfter, err = r.reconcileProxy(ctx, config, status)
+		if renewAfter > 0 && (result.RequeueAfter == 0 || result.RequeueAfter > renewAfter) {
+			result.RequeueAfter = renewAfter
+		}
+	} else {
+		status.setCondition(v1alpha1.ConditionTypeReady, metav1.ConditionFalse, v1alpha1.ReasonDisabled,
+			"The Insights proxy is disabled")
+		status.setCondition(v1alpha1.ConditionTypeDegraded, metav1.ConditionFalse, v1alpha1.ReasonDisabled,
+			"The Insights proxy is disabled")
+		// Check as soon as the proxy is enabled again
+		r.lastConnectivityCheck = time.Time{}
+		if r.Shared != nil {
+			// The proxy is only deleted once no other operator uses it
+			err = r.leaveSharedProxy(ctx)
+		} else {
+			err = r.deleteConfigMap(ctx)
+		}
+	}
+
+	if proxy != nil {
+		statusErr := r.updateInsightsProxyStatus(ctx, proxy, config, status)
+		if err == nil {
+			err = statusErr
+		}
+	}
+	recordReconcileMetrics(r.names.Instance, config, err)
+	return result, err
+}
+
+// reconcileProxy returns how long until the proxy should be reconciled again,
+// or zero if it only needs to be reconciled when its resources change
+func (r *InsightsReconciler) reconcileProxy(ctx context.Context, config *proxyConfig, status *insightsStatus) (time.Duration, error) {
+	requeueAfter, err := r.reconcileProxyResources(ctx, config, status)
+	status.setResult(err)
+	// Persist the status on the config map, so it can be inspected
+	// even without the InsightsProxy API
+	statusErr := r.updateConfigMapStatus(ctx, status)
+	if err == nil {
+		err = statusErr
+	}
+	return requeueAfter, err
+}
+
+func (r *InsightsReconciler) reconcileProxyResources(ctx context.Context, config *proxyConfig, status *insightsStatus) (time.Duration, error) {
+	err := r.reconcileConfigMap(ctx)
+	if err != nil {
+		return 0, newReconcileError(v1alpha1.ReasonConfigMapFailed, err)
+	}
+	// The image and implementation depend on the architectures of the cluster's nodes
+	err = r.placeProxy(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	apiCastConfig, err := r.reconcilePullSecret(ctx, config, status)
+	if err != nil {
+		return 0, err
+	}
+	// Certificates must be renewed before they expire
+	renewAfter, err := r.reconcileProxyTLS(ctx)
+	if err != nil {
+		return 0, newReconcileError(v1alpha1.ReasonTLSFailed, err)
+	}
+	// Tokens must be refreshed before they expire, and changes
+	// to tokens outside of Secrets cannot be watched
+	refreshAfter := time.Duration(0)
+	if source, ok := config.tokenSource.(ExpiringTokenSource); ok {
+		refreshAfter = source.RefreshAfter()
+	} else if _, ok := config.tokenSource.(SecretTokenSource); !ok {
+		refreshAfter = TokenRefreshInterval
+	}
+	if refreshAfter > 0 && (renewAfter == 0 || renewAfter > refreshAfter) {
+		renewAfter = refreshAfter
+	}
+	tlsCert, err := r.getProxyTLSCertificate(ctx)
+	if err != nil {
+		return 0, newReconcileError(v1alpha1.ReasonTLSFailed, err)
+	}
+	trustedCA, err := r.reconcileTrustedCABundle(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	config.trustedCA = trustedCA != nil
+	// Roll the proxy when the files it loads at startup change
+	configHash := hashProxyConfig([]byte(apiCastConfig), tlsCert, trustedCA)
+	err = r.reconcileProxyDeployment(ctx, config, configHash, status)
+	if err != nil {
+		return 0, newReconcileError(v1alpha1.ReasonDeploymentFailed, err)
+	}
+	err = r.reconcileProxyService(ctx, config)
+	if err != nil {
+		return 0, newReconcileError(v1alpha1.ReasonServiceFailed, err)
+	}
+	// Verify periodically that Red Hat Insights accepts requests from the proxy
+	checkAfter := r.reconcileConnectivity(ctx, status)
+	if checkAfter > 0 && (renewAfter == 0 || renewAfter > checkAfter) {
+		renewAfter = checkAfter
+	}
+	return renewAfter, nil
+}
+
+func (r *InsightsReconciler) getInsightsProxy(ctx context.Context) (*v1alpha1.InsightsProxy, error) {
+	proxy := &v1alpha1.InsightsProxy{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.InsightsProxy,
+		Namespace: r.Namespace}, proxy)
+	if err != nil {
+		// Fall back to defaults if there is no InsightsProxy, or its CRD is not installed
+		if kerrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
 	}
-	err = r.reconcileProxyDeployment(ctx)
+	return proxy, nil
+}
+
+func (r *InsightsReconciler) getProxyConfig(proxy *v1alpha1.InsightsProxy) (*proxyConfig, error) {
+	implementation, err := parseProxyImplementation(r.implementation)
 	if err != nil {
+		return nil, err
+	}
+	config := &proxyConfig{
+		enabled:            r.enabled,
+		implementation:     implementation,
+		backendDomain:      r.backendDomain,
+		tokenSource:        r.tokenSource,
+		clusterID:          r.clusterID,
+		trustedCAConfigMap: r.trustedCAConfigMap,
+	}
+	upstreamProxy := r.upstreamProxy
+	proxyCredentialsSecret := r.proxyCredentialsSecret
+	noProxy := r.noProxy
+	if proxy != nil {
+		spec := proxy.Spec
+		if spec.Enabled != nil {
+			config.enabled = *spec.Enabled
+		}
+		if len(spec.Implementation) > 0 {
+			config.implementation = spec.Implementation
+		}
+		if len(spec.BackendDomain) > 0 {
+			config.backendDomain = spec.BackendDomain
+		}
+		if len(spec.UpstreamProxy) > 0 {
+			upstreamProxy = spec.UpstreamProxy
+			// Never send credentials from the environment to a different proxy
+			proxyCredentialsSecret = spec.UpstreamProxyCredentialsSecret
+		} else if len(spec.UpstreamProxyCredentialsSecret) > 0 {
+			proxyCredentialsSecret = spec.UpstreamProxyCredentialsSecret
+		}
+		if len(spec.NoProxy) > 0 {
+			noProxy = spec.NoProxy
+		}
+		if len(spec.TokenSecret) > 0 {
+			config.tokenSource = NewSecretKeyTokenSource(r.Namespace, spec.TokenSecret, common.TokenSecretKey)
+		}
+		if len(spec.ClusterID) > 0 {
+			config.clusterID = spec.ClusterID
+		}
+		config.imageOverride = spec.Image
+		config.resources = spec.Resources
+	}
+
+	if config.enabled {
+		if len(config.backendDomain) == 0 {
+			return nil, errors.New("no backend domain provided for Insights")
+		}
+		if len(upstreamProxy) > 0 {
+			config.upstreamProxy, err = parseUpstreamProxy(upstreamProxy)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(proxyCredentialsSecret) > 0 {
+			if config.upstreamProxy == nil {
+				return nil, errors.New("upstream proxy credentials provided without an upstream proxy")
+			}
+			config.proxyCredentialsSecret = proxyCredentialsSecret
+		}
+		config.noProxy, err = parseNoProxy(noProxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func (r *InsightsReconciler) updateInsightsProxyStatus(ctx context.Context, proxy *v1alpha1.InsightsProxy,
+	config *proxyConfig, status *insightsStatus) error {
+	proxyURL := ""
+	if config != nil && config.enabled {
+		proxyURL = GetProxyURL(r.names.Service, r.Namespace, r.clusterDomain, r.ports.HTTPS).String()
+	} else {
+		// These no longer apply when no proxy is deployed
+		meta.RemoveStatusCondition(&proxy.Status.Conditions, v1alpha1.ConditionTypeTokenAvailable)
+		meta.RemoveStatusCondition(&proxy.Status.Conditions, v1alpha1.ConditionTypeProxyAvailable)
+		meta.RemoveStatusCondition(&proxy.Status.Conditions, v1alpha1.ConditionTypeBackendConnected)
+	}
+
+	status.applyTo(&proxy.Status.Conditions, proxy.Generation)
+	proxy.Status.ProxyURL = proxyURL
+	proxy.Status.ObservedGeneration = proxy.Generation
+	return r.Client.Status().Update(ctx, proxy)
+}
+
+func (r *InsightsReconciler) reconcileConfigMap(ctx context.Context) error {
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.ConfigMap,
+		Namespace: r.Namespace}, cm)
+	// The config map is normally created by InsightsIntegration, but will be
+	// missing if Insights was enabled after the operator started
+	if !kerrors.IsNotFound(err) || len(r.OperatorName) == 0 {
 		return err
 	}
-	return r.reconcileProxyService(ctx)
+
+	cm = &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.names.ConfigMap,
+			Namespace: r.Namespace,
+		},
+	}
+	// A shared proxy must outlive the operator that created it, and is deleted
+	// by the last operator to leave it instead
+	if r.Shared == nil {
+		// The config map should be owned by the operator deployment to ensure it and its descendants are garbage collected
+		owner := &appsv1.Deployment{}
+		err = r.Client.Get(ctx, types.NamespacedName{Name: r.OperatorName, Namespace: r.Namespace}, owner)
+		if err != nil {
+			return err
+		}
+		err = controllerutil.SetControllerReference(owner, cm, r.Scheme)
+		if err != nil {
+			return err
+		}
+	}
+	err = r.Client.Create(ctx, cm)
+	if err == nil {
+		r.Log.Info("Config Map for Insights created", "name", cm.Name, "namespace", cm.Namespace)
+	}
+	return client.IgnoreAlreadyExists(err)
+}
+
+func (r *InsightsReconciler) deleteConfigMap(ctx context.Context) error {
+	// Children will be garbage collected
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.ConfigMap,
+		Namespace: r.Namespace}, cm)
+	if err == nil {
+		err = r.Client.Delete(ctx, cm)
+	}
+	if err == nil {
+		r.Log.Info("Config Map for Insights deleted", "name", cm.Name, "namespace", cm.Namespace)
+		r.recordEvent([]runtime.Object{cm}, corev1.EventTypeNormal, EventReasonProxyDeleted,
+			"Deleted the Insights proxy because Insights is disabled")
+	}
+	// This may not exist if no config map was previously created
+	return client.IgnoreNotFound(err)
 }
 
-func (r *InsightsReconciler) reconcilePullSecret(ctx context.Context) error {
+// reconcilePullSecret returns the APICast configuration written to the proxy's secret
+func (r *InsightsReconciler) reconcilePullSecret(ctx context.Context, config *proxyConfig, status *insightsStatus) (string, error) {
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      common.ProxySecretName,
+			Name:      r.names.ProxySecret,
 			Namespace: r.Namespace,
 		},
 	}
 	owner := &corev1.ConfigMap{}
-	err := r.Client.Get(ctx, types.NamespacedName{Name: common.InsightsConfigMapName,
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.ConfigMap,
 		Namespace: r.Namespace}, owner)
 	if err != nil {
-		return err
+		return "", newReconcileError(v1alpha1.ReasonConfigMapFailed, err)
 	}
 
-	token, err := r.getTokenFromPullSecret(ctx)
+	token, source, err := r.getToken(ctx, config)
 	if err != nil {
-		return err
+		status.setCondition(v1alpha1.ConditionTypeTokenAvailable, metav1.ConditionFalse, reasonForError(err), err.Error())
+		tokenPresent.WithLabelValues(r.names.Instance).Set(0)
+		r.recordWarning(owner, err)
+		return "", err
 	}
+	tokenPresent.WithLabelValues(r.names.Instance).Set(1)
+	status.setCondition(v1alpha1.ConditionTypeTokenAvailable, metav1.ConditionTrue, v1alpha1.ReasonTokenFound,
+		fmt.Sprintf("Found a token in %s", source.String()))
 
-	userAgent, err := r.getUserAgentString(ctx)
+	userAgent, members, err := r.getUserAgents(ctx, config)
 	if err != nil {
-		return err
+		r.recordWarning(owner, err)
+		return "", err
+	}
+
+	upstream, err := r.getUpstreamProxy(ctx, config)
+	if err != nil {
+		return "", err
 	}
 
 	params := &apiCastConfigParams{
-		FrontendDomains:       fmt.Sprintf("\"%s\",\"%s.%s.svc.cluster.local\"", common.ProxyServiceName, common.ProxyServiceName, r.Namespace),
-		BackendInsightsDomain: r.backendDomain,
-		ProxyDomain:           r.proxyDomain,
-		HeaderValue:           *token,
-		UserAgent:             *userAgent,
+		FrontendDomains:       []string{r.names.Service, getProxyServiceFQDN(r.names.Service, r.Namespace, r.clusterDomain)},
+		BackendInsightsDomain: config.backendDomain,
+		HeaderValue:           token,
+		UserAgent:             userAgent,
+		Members:               members,
+		Policies:              r.APICastPolicies,
+	}
+	if upstream != nil {
+		params.ProxyURL = upstream.String()
+	}
+	var apiCastConfig *string
+	if config.implementation == v1alpha1.ProxyImplementationBuiltin {
+		apiCastConfig, err = getBuiltinProxyConfig(params)
+	} else {
+		apiCastConfig, err = getAPICastConfig(params)
 	}
-	config, err := getAPICastConfig(params)
 	if err != nil {
-		return err
+		return "", newReconcileError(v1alpha1.ReasonSecretFailed, err)
 	}
 
-	return r.createOrUpdateProxySecret(ctx, secret, owner, *config)
+	rotated, err := r.createOrUpdateProxySecret(ctx, secret, owner, config.implementation, *apiCastConfig)
+	if err != nil {
+		return "", newReconcileError(v1alpha1.ReasonSecretFailed, err)
+	}
+	if rotated {
+		configRotations.Inc()
+		r.recordEvent([]runtime.Object{owner}, corev1.EventTypeNormal, EventReasonConfigRotated,
+			"Updated the Insights proxy configuration")
+	}
+	// An equivalent existing configuration is kept as is
+	return string(secret.Data["config.json"]), nil
 }
 
-func (r *InsightsReconciler) reconcileProxyDeployment(ctx context.Context) error {
+func (r *InsightsReconciler) reconcileProxyDeployment(ctx context.Context, config *proxyConfig, configHash string,
+	status *insightsStatus) error {
 	deploy := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      common.ProxyDeploymentName,
+			Name:      r.names.Deployment,
 			Namespace: r.Namespace,
 		},
 	}
 	owner := &corev1.ConfigMap{}
-	err := r.Client.Get(ctx, types.NamespacedName{Name: common.InsightsConfigMapName,
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.ConfigMap,
 		Namespace: r.Namespace}, owner)
 	if err != nil {
 		return err
 	}
 
-	return r.createOrUpdateProxyDeployment(ctx, deploy, owner)
+	// The proxy's pods must be admitted by the namespace's pod security level
+	config.podSecurityLevel, err = r.getPodSecurityLevel(ctx)
+	if err != nil {
+		return err
+	}
+	op, err := r.createOrUpdateProxyDeployment(ctx, deploy, owner, config, configHash)
+	if err != nil {
+		return err
+	}
+	if op == controllerutil.OperationResultCreated {
+		r.recordEvent([]runtime.Object{owner, deploy}, corev1.EventTypeNormal, EventReasonProxyCreated,
+			fmt.Sprintf("Created the Insights proxy Deployment %s", deploy.Name))
+	}
+
+	proxyAvailableReplicas.WithLabelValues(r.names.Instance).Set(float64(deploy.Status.AvailableReplicas))
+	if deploy.Status.AvailableReplicas > 0 {
+		status.setCondition(v1alpha1.ConditionTypeProxyAvailable, metav1.ConditionTrue, v1alpha1.ReasonDeploymentAvailable,
+			fmt.Sprintf("The Insights proxy has %d available replica(s)", deploy.Status.AvailableReplicas))
+	} else {
+		status.setCondition(v1alpha1.ConditionTypeProxyAvailable, metav1.ConditionFalse, v1alpha1.ReasonDeploymentUnavailable,
+			"The Insights proxy has no available replicas")
+	}
+	return nil
+}
+
+// getPodSecurityLevel returns the Pod Security Admission level enforced
+// in the operator's namespace, or an empty string if none is set
+func (r *InsightsReconciler) getPodSecurityLevel(ctx context.Context) (string, error) {
+	ns := &corev1.Namespace{}
+	err := r.getAPIReader().Get(ctx, types.NamespacedName{Name: r.Namespace}, ns)
+	if err != nil {
+		return "", err
+	}
+	return ns.Labels[common.PodSecurityEnforceLabel], nil
 }
 
-func (r *InsightsReconciler) reconcileProxyService(ctx context.Context) error {
+func (r *InsightsReconciler) reconcileProxyService(ctx context.Context, config *proxyConfig) error {
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      common.ProxyServiceName,
+			Name:      r.names.Service,
 			Namespace: r.Namespace,
 		},
 	}
 	owner := &corev1.ConfigMap{}
-	err := r.Client.Get(ctx, types.NamespacedName{Name: common.InsightsConfigMapName,
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.ConfigMap,
 		Namespace: r.Namespace}, owner)
 	if err != nil {
 		return err
 	}
 
-	return r.createOrUpdateProxyService(ctx, svc, owner)
-}
-
-func (r *InsightsReconciler) getTokenFromPullSecret(ctx context.Context) (*string, error) {
-	// Get the global pull secret
-	pullSecret := &corev1.Secret{}
-	err := r.Client.Get(ctx, types.NamespacedName{Namespace: "openshift-config", Name: "pull-secret"}, pullSecret)
+	servingCerts, err := r.useServingCerts()
 	if err != nil {
-		return nil, err
-	}
-
-	// Look for the .dockerconfigjson key within it
-	dockerConfigRaw, pres := pullSecret.Data[corev1.DockerConfigJsonKey]
-	if !pres {
-		return nil, fmt.Errorf("no %s key present in pull secret", corev1.DockerConfigJsonKey)
+		return err
 	}
+	return r.createOrUpdateProxyService(ctx, svc, owner, config, servingCerts)
+}
 
-	// Unmarshal the .dockerconfigjson into a struct
-	dockerConfig := struct {
-		Auths map[string]struct {
-			Auth string `json:"auth"`
-		} `json:"auths"`
-	}{}
-	err = json.Unmarshal(dockerConfigRaw, &dockerConfig)
+// getUserAgents returns the User-Agent reported for this operator's clients, along with
+// the User-Agents of the operators using a shared proxy for requests beneath their paths
+func (r *InsightsReconciler) getUserAgents(ctx context.Context, config *proxyConfig) (string, []proxy.Member, error) {
+	clusterID, err := r.getClusterID(ctx, config)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
-	// Look for the "cloud.openshift.com" auth
-	openshiftAuth, pres := dockerConfig.Auths["cloud.openshift.com"]
-	if !pres {
-		return nil, errors.New("no \"cloud.openshift.com\" auth within pull secret")
+	members := []proxy.Member{}
+	for _, member := range config.sharedMembers {
+		members = append(members, proxy.Member{
+			PathPrefix: member.pathPrefix,
+			UserAgent:  getUserAgentString(member.userAgentPrefix, clusterID),
+		})
 	}
-
-	token := strings.TrimSpace(openshiftAuth.Auth)
-	if strings.Contains(token, "\n") || strings.Contains(token, "\r") {
-		return nil, fmt.Errorf("invalid cloud.openshift.com token")
-	}
-	return &token, nil
+	return getUserAgentString(r.UserAgentPrefix, clusterID), members, nil
 }
 
-func (r *InsightsReconciler) getUserAgentString(ctx context.Context) (*string, error) {
-	cv := &configv1.ClusterVersion{}
-	err := r.Client.Get(ctx, types.NamespacedName{Name: "version"}, cv)
-	if err != nil {
-		return nil, err
-	}
-
-	userAgent := fmt.Sprintf("%s cluster/%s", r.UserAgentPrefix, cv.Spec.ClusterID)
-	return &userAgent, nil
+func getUserAgentString(prefix string, clusterID string) string {
+	return fmt.Sprintf("%s cluster/%s", prefix, clusterID)
 }
 
+// createOrUpdateProxySecret returns whether an existing configuration was replaced
 func (r *InsightsReconciler) createOrUpdateProxySecret(ctx context.Context, secret *corev1.Secret, owner metav1.Object,
-	config string) error {
+	implementation v1alpha1.ProxyImplementation, config string) (bool, error) {
+	rotated := false
 	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		common.MergeLabelsAndAnnotations(&secret.ObjectMeta, r.names.Labels(r.OperatorName), nil)
 		// Set the config map as controller
 		if err := controllerutil.SetControllerReference(owner, secret, r.Scheme); err != nil {
 			return err
 		}
-		// Add the APICast config.json
-		if secret.StringData == nil {
-			secret.StringData = map[string]string{}
+		existing, pres := secret.Data["config.json"]
+		if pres && isProxyConfigEqual(implementation, existing, []byte(config)) {
+			return nil
 		}
-		secret.StringData["config.json"] = config
+		rotated = pres
+		// Add the APICast config.json. Setting Data rather than StringData
+		// allows the update to be skipped when the config is unchanged.
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data["config.json"] = []byte(config)
 		return nil
 	})
 	if err != nil {
-		return err
+		return false, err
 	}
 	r.Log.Info(fmt.Sprintf("Secret %s", op), "name", secret.Name, "namespace", secret.Namespace)
-	return nil
+	return rotated, nil
 }
 
-func (r *InsightsReconciler) createOrUpdateProxyDeployment(ctx context.Context, deploy *appsv1.Deployment, owner metav1.Object) error {
+// isProxyConfigEqual returns whether an existing proxy configuration is equivalent to
+// a new one. APICast configurations are compared by their content, ignoring formatting.
+func isProxyConfigEqual(implementation v1alpha1.ProxyImplementation, existing []byte, config []byte) bool {
+	if implementation == v1alpha1.ProxyImplementationBuiltin {
+		return string(existing) == string(config)
+	}
+	existingConfig, err := ParseAPICastConfig(existing)
+	if err != nil {
+		return false
+	}
+	newConfig, err := ParseAPICastConfig(config)
+	if err != nil {
+		return false
+	}
+	return existingConfig.Equal(newConfig)
+}
+
+func (r *InsightsReconciler) createOrUpdateProxyDeployment(ctx context.Context, deploy *appsv1.Deployment, owner metav1.Object,
+	config *proxyConfig, configHash string) (controllerutil.OperationResult, error) {
 	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
-		labels := map[string]string{"app": common.ProxyDeploymentName}
+		labels := r.names.Labels(r.OperatorName)
 		annotations := map[string]string{}
 		common.MergeLabelsAndAnnotations(&deploy.ObjectMeta, labels, annotations)
 		// Set the config map as controller
@@ -201,29 +601,38 @@ func (r *InsightsReconciler) createOrUpdateProxyDeployment(ctx context.Context,
 			// Selector is immutable, avoid modifying if possible
 			deploy.Spec.Selector = &metav1.LabelSelector{
 				MatchLabels: map[string]string{
-					"app": common.ProxyDeploymentName,
+					"app": r.names.Deployment,
 				},
 			}
 		}
 
 		// Update pod template spec
-		r.createOrUpdateProxyPodSpec(deploy)
-		// Update pod template metadata
-		common.MergeLabelsAndAnnotations(&deploy.Spec.Template.ObjectMeta, labels, annotations)
+		r.createOrUpdateProxyPodSpec(deploy, config)
+		// Update pod template metadata, changing the config hash triggers a rollout
+		// Only the selected label, so that adding labels to the Deployment doesn't trigger a rollout
+		templateLabels := map[string]string{"app": r.names.Deployment}
+		templateAnnotations := map[string]string{common.ProxyConfigHashAnnotation: configHash}
+		common.MergeLabelsAndAnnotations(&deploy.Spec.Template.ObjectMeta, templateLabels, templateAnnotations)
 		return nil
 	})
 	if err != nil {
-		return err
+		return op, err
 	}
 	r.Log.Info(fmt.Sprintf("Deployment %s", op), "name", deploy.Name, "namespace", deploy.Namespace)
-	return nil
+	return op, nil
 }
 
-func (r *InsightsReconciler) createOrUpdateProxyService(ctx context.Context, svc *corev1.Service, owner metav1.Object) error {
+func (r *InsightsReconciler) createOrUpdateProxyService(ctx context.Context, svc *corev1.Service, owner metav1.Object,
+	config *proxyConfig, servingCerts bool) error {
+	ports := r.ports.forImplementation(config.implementation)
 	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
 		// Update labels and annotations
-		labels := map[string]string{"app": common.ProxyDeploymentName}
+		labels := r.names.Labels(r.OperatorName)
 		annotations := map[string]string{}
+		if servingCerts {
+			// Request a certificate from the OpenShift service CA operator
+			annotations[servingCertSecretAnnotation] = r.names.TLSSecret
+		}
 		common.MergeLabelsAndAnnotations(&svc.ObjectMeta, labels, annotations)
 
 		// Set the config map as controller
@@ -233,17 +642,17 @@ func (r *InsightsReconciler) createOrUpdateProxyService(ctx context.Context, svc
 		// Update the service type
 		svc.Spec.Type = corev1.ServiceTypeClusterIP
 		svc.Spec.Selector = map[string]string{
-			"app": common.ProxyDeploymentName,
+			"app": r.names.Deployment,
 		}
 		svc.Spec.Ports = []corev1.ServicePort{
 			{
 				Name:       "proxy",
-				Port:       common.ProxyServicePort,
-				TargetPort: intstr.FromString("proxy"),
+				Port:       ports.HTTPS,
+				TargetPort: intstr.FromString("https"),
 			},
 			{
 				Name:       "management",
-				Port:       8090,
+				Port:       ports.Management,
 				TargetPort: intstr.FromString("management"),
 			},
 		}
@@ -264,11 +673,17 @@ const (
 	// ALL capability to drop for restricted pod security. See:
 	// https://kubernetes.io/docs/concepts/security/pod-security-standards/#restricted
 	capabilityAll corev1.Capability = "ALL"
+	// Where the proxy's configuration is mounted
+	configMountPath = "/tmp/gateway-configuration-volume"
+	// Where the proxy's serving certificate is mounted
+	tlsMountPath = "/var/run/secrets/insights-proxy/tls"
+	// User both the APICast and built-in proxy images run as,
+	// used where SCCs do not assign one
+	proxyUID int64 = 1001
 )
 
-func (r *InsightsReconciler) createOrUpdateProxyPodSpec(deploy *appsv1.Deployment) {
+func (r *InsightsReconciler) createOrUpdateProxyPodSpec(deploy *appsv1.Deployment, config *proxyConfig) {
 	privEscalation := false
-	nonRoot := true
 	readOnlyMode := int32(0440)
 
 	podSpec := &deploy.Spec.Template.Spec
@@ -280,33 +695,24 @@ func (r *InsightsReconciler) createOrUpdateProxyPodSpec(deploy *appsv1.Deploymen
 	container = &podSpec.Containers[0]
 
 	// Set fields that are hard-coded by operator
+	ports := r.ports.forImplementation(config.implementation)
 	container.Name = common.ProxyDeploymentName
-	container.Image = r.proxyImageTag
-	container.Env = []corev1.EnvVar{
-		{
-			Name:  "THREESCALE_CONFIG_FILE",
-			Value: "/tmp/gateway-configuration-volume/config.json",
-		},
+	container.Image = config.proxyImageTag
+	if config.implementation == v1alpha1.ProxyImplementationBuiltin {
+		setBuiltinProxyContainer(container, ports)
+	} else {
+		setAPICastContainer(container, ports)
 	}
 	container.VolumeMounts = []corev1.VolumeMount{
 		{
 			Name:      "gateway-configuration-volume",
-			MountPath: "/tmp/gateway-configuration-volume",
+			MountPath: configMountPath,
 			ReadOnly:  true,
 		},
-	}
-	container.Ports = []corev1.ContainerPort{
-		{
-			Name:          "proxy",
-			ContainerPort: common.ProxyServicePort,
-		},
 		{
-			Name:          "management",
-			ContainerPort: 8090,
-		},
-		{
-			Name:          "metrics",
-			ContainerPort: 9421,
+			Name:      "tls-secret",
+			MountPath: tlsMountPath,
+			ReadOnly:  true,
 		},
 	}
 	container.SecurityContext = &corev1.SecurityContext{
@@ -321,7 +727,7 @@ func (r *InsightsReconciler) createOrUpdateProxyPodSpec(deploy *appsv1.Deploymen
 		ProbeHandler: corev1.ProbeHandler{
 			HTTPGet: &corev1.HTTPGetAction{
 				Path: "/status/live",
-				Port: intstr.FromInt(8090),
+				Port: intstr.FromInt(int(ports.Management)),
 			},
 		},
 	}
@@ -332,14 +738,17 @@ func (r *InsightsReconciler) createOrUpdateProxyPodSpec(deploy *appsv1.Deploymen
 		ProbeHandler: corev1.ProbeHandler{
 			HTTPGet: &corev1.HTTPGetAction{
 				Path: "/status/ready",
-				Port: intstr.FromInt(8090),
+				Port: intstr.FromInt(int(ports.Management)),
 			},
 		},
 	}
 
-	// Set resource requirements only on creation, this allows
-	// the user to modify them if they wish
-	if deploy.CreationTimestamp.IsZero() {
+	// Use resource requirements from the InsightsProxy if specified.
+	// Otherwise, set them only on creation, this allows the user
+	// to modify them if they wish
+	if config.resources != nil {
+		container.Resources = *config.resources
+	} else if deploy.CreationTimestamp.IsZero() {
 		container.Resources = corev1.ResourceRequirements{
 			Requests: corev1.ResourceList{
 				corev1.ResourceCPU:    resource.MustParse(defaultProxyCPURequest),
@@ -352,12 +761,12 @@ func (r *InsightsReconciler) createOrUpdateProxyPodSpec(deploy *appsv1.Deploymen
 		}
 	}
 
-	podSpec.Volumes = []corev1.Volume{ // TODO detect change and redeploy
+	podSpec.Volumes = []corev1.Volume{
 		{
 			Name: "gateway-configuration-volume",
 			VolumeSource: corev1.VolumeSource{
 				Secret: &corev1.SecretVolumeSource{
-					SecretName: common.ProxySecretName,
+					SecretName: r.names.ProxySecret,
 					Items: []corev1.KeyToPath{
 						{
 							Key:  "config.json",
@@ -368,9 +777,76 @@ func (r *InsightsReconciler) createOrUpdateProxyPodSpec(deploy *appsv1.Deploymen
 				},
 			},
 		},
+		{
+			Name: "tls-secret",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  r.names.TLSSecret,
+					DefaultMode: &readOnlyMode,
+				},
+			},
+		},
 	}
-	podSpec.SecurityContext = &corev1.PodSecurityContext{
-		RunAsNonRoot:   &nonRoot,
-		SeccompProfile: common.SeccompProfile(true),
+	if config.trustedCA {
+		setTrustedCABundle(podSpec, container, r.names.TrustedCABundleConfigMap)
+	}
+	podSpec.SecurityContext = r.getPlatform().PodSecurityContext(config.podSecurityLevel, proxyUID)
+	// Only schedule onto nodes the image can run on
+	podSpec.Affinity = newArchitectureAffinity(config.architectures)
+}
+
+func setAPICastContainer(container *corev1.Container, ports ProxyPorts) {
+	container.Args = nil
+	container.Env = []corev1.EnvVar{
+		{
+			Name:  "THREESCALE_CONFIG_FILE",
+			Value: path.Join(configMountPath, "config.json"),
+		},
+		{
+			Name:  "APICAST_HTTPS_PORT",
+			Value: strconv.Itoa(int(ports.HTTPS)),
+		},
+		{
+			Name:  "APICAST_HTTPS_CERTIFICATE",
+			Value: path.Join(tlsMountPath, corev1.TLSCertKey),
+		},
+		{
+			Name:  "APICAST_HTTPS_CERTIFICATE_KEY",
+			Value: path.Join(tlsMountPath, corev1.TLSPrivateKeyKey),
+		},
+	}
+	container.Ports = []corev1.ContainerPort{
+		{
+			Name:          "proxy",
+			ContainerPort: ports.HTTP,
+		},
+		{
+			Name:          "https",
+			ContainerPort: ports.HTTPS,
+		},
+		{
+			Name:          "management",
+			ContainerPort: ports.Management,
+		},
+		{
+			Name:          "metrics",
+			ContainerPort: ports.Metrics,
+		},
+	}
+	// Only set when changed, to avoid rolling existing proxies
+	if ports.HTTP != DefaultProxyPorts.HTTP {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "APICAST_HTTP_PORT",
+			Value: strconv.Itoa(int(ports.HTTP)),
+		})
+	}
+}
+
+// hashProxyConfig returns a digest of the contents of files mounted into the proxy
+func hashProxyConfig(contents ...[]byte) string {
+	hash := sha256.New()
+	for _, content := range contents {
+		hash.Write(content)
 	}
+	return hex.EncodeToString(hash.Sum(nil))
 }
@@ -17,27 +17,57 @@ package controller
 import (
 	"context"
 	"errors"
+	"net"
+	"sync"
+	"time"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
 	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
 	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimecontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // InsightsReconciler reconciles the Insights proxy for Cryostat agents
+// Fields other than InsightsReconcilerConfig are defaults obtained from the environment,
+// which may be overridden by an InsightsProxy resource
 type InsightsReconciler struct {
 	*InsightsReconcilerConfig
-	backendDomain string
-	proxyDomain   string
-	proxyImageTag string
+	enabled                bool
+	backendDomain          string
+	upstreamProxy          string
+	proxyCredentialsSecret string
+	noProxy                string
+	proxyImageTags         map[string]string
+	builtinProxyImageTag   string
+	implementation         string
+	tokenSource            TokenSource
+	pullSecret             *PullSecretTokenSource
+	clusterID              string
+	trustedCAConfigMap     string
+	clusterDomain          string
+	ports                  ProxyPorts
+	names                  *common.ResourceNames
+	// Secrets named by the InsightsProxy when last reconciled
+	proxySecrets referencedSecrets
+	// Time of the last connectivity check, zero if one is due
+	lastConnectivityCheck time.Time
+	// Dials the proxy for connectivity checks, a net.Dialer if unset
+	dialContext func(ctx context.Context, network string, addr string) (net.Conn, error)
 }
 
 // InsightsReconcilerConfig contains configuration to create an InsightsReconciler
@@ -45,35 +75,169 @@ type InsightsReconcilerConfig struct {
 	client.Client
 	Log             logr.Logger
 	Scheme          *runtime.Scheme
+	Recorder        record.EventRecorder
 	Namespace       string
 	UserAgentPrefix string
+	// Name of the operator's Deployment, used as the owner of
+	// the Insights config map if it needs to be recreated
+	OperatorName string
+	// Optional reader for objects outside of the manager's cache,
+	// the Client is used if unset
+	APIReader client.Reader
+	// Platform the operator runs on, detected using common.DetectPlatform.
+	// If unset, a Kubernetes cluster without SCCs is assumed.
+	Platform *common.Platform
+	// Optional source of the token used to authenticate with Red Hat Insights.
+	// If unset, the Secret named by INSIGHTS_TOKEN_SECRET is used, then credentials
+	// for Red Hat SSO in the Secret named by INSIGHTS_SSO_SECRET, or the global
+	// pull secret on OpenShift.
+	TokenSource TokenSource
+	// Optional policies inserted into the generated APICast policy chain.
+	// These are not used by the built-in proxy.
+	APICastPolicies []APICastPolicyExtension
+	// Optional names of custom policies provided by the APICast image,
+	// which may be used in APICastPolicies
+	CustomAPICastPolicies []string
+	// Optional defaults for the proxy, taking precedence over the environment
+	Defaults ProxyDefaults
+	// Optional DNS domain of the cluster, defaults to cluster.local
+	ClusterDomain string
+	// Optional ports used by the proxy, defaults to DefaultProxyPorts
+	Ports ProxyPorts
+	// Optional options for the controller, such as the maximum number of concurrent reconciles
+	ControllerOptions runtimecontroller.Options
+	// Optional name of this instance of the integration, prefixing the names of the
+	// objects it manages so that several operators may share a namespace
+	Instance string
+	// Optional configuration to share the proxy with other operators,
+	// in which case Namespace is that of the shared proxy
+	Shared *SharedProxyConfig
+	// Optional interval between checks that requests sent through the proxy are accepted by
+	// Red Hat Insights, defaults to DefaultConnectivityCheckInterval. Negative disables checks.
+	ConnectivityCheckInterval time.Duration
 	common.OSUtils
 }
 
 // NewInsightsReconciler creates an InsightsReconciler using the provided configuration
 func NewInsightsReconciler(config *InsightsReconcilerConfig) (*InsightsReconciler, error) {
-	backendDomain := config.GetEnv(common.EnvInsightsBackendDomain)
-	if len(backendDomain) == 0 {
-		return nil, errors.New("no backend domain provided for Insights")
+	names := common.NewResourceNames(config.Instance)
+	err := names.Validate()
+	if err != nil {
+		return nil, err
+	}
+	// Members of a shared proxy are identified by their operator's name and namespace
+	if config.Shared != nil && (len(config.OperatorName) == 0 || len(config.Shared.OperatorNamespace) == 0) {
+		return nil, errors.New("the operator's name and namespace are required to share the Insights proxy")
+	}
+	// Policies are provided by the operator, so fail early rather than when reconciling
+	err = validateAPICastPolicies(config.APICastPolicies, config.CustomAPICastPolicies)
+	if err != nil {
+		return nil, err
+	}
+	// Ports are provided by the operator, so fail early rather than when reconciling
+	ports := config.Ports.WithDefaults()
+	err = ports.validate()
+	if err != nil {
+		return nil, err
 	}
-	imageTag := config.GetEnv(common.EnvInsightsProxyImageTag)
-	if len(imageTag) == 0 {
-		return nil, errors.New("no proxy image tag provided for Insights")
+	clusterDomain := config.ClusterDomain
+	if len(clusterDomain) == 0 {
+		clusterDomain = common.DefaultClusterDomain
 	}
-	proxyDomain := config.GetEnv(common.EnvInsightsProxyDomain)
+	// These are only defaults, an InsightsProxy resource may override them.
+	// Those provided programmatically take precedence over the environment.
+	// Required values are validated when reconciling.
+	defaults := &config.Defaults
+	enabled := defaults.IsEnabled(config.OSUtils)
+	backendDomain := getDefault(defaults.BackendDomain, config.GetEnv(common.EnvInsightsBackendDomain))
+	// APICast images are provided separately for each architecture
+	imageTags := map[string]string{}
+	for arch, env := range map[string]string{
+		archAMD64:   common.EnvInsightsProxyImageTag,
+		archARM64:   common.EnvInsightsProxyImageTagARM64,
+		archPPC64LE: common.EnvInsightsProxyImageTagPPC64LE,
+		archS390X:   common.EnvInsightsProxyImageTagS390X,
+	} {
+		if imageTag := getDefault(defaults.ProxyImages[arch], config.GetEnv(env)); len(imageTag) > 0 {
+			imageTags[arch] = imageTag
+		}
+	}
+	// Validated when reconciling, so that errors are reported in the status
+	upstreamProxy := getDefault(defaults.UpstreamProxy, config.GetEnv(common.EnvInsightsProxyURL))
+	if len(upstreamProxy) == 0 {
+		upstreamProxy = config.GetEnv(common.EnvInsightsProxyDomain)
+	}
+	proxyCredentialsSecret := config.GetEnv(common.EnvInsightsProxyCredentialsSecret)
+	noProxy := config.GetEnv(common.EnvInsightsNoProxy)
+	builtinImageTag := getDefault(defaults.BuiltinProxyImage, config.GetEnv(common.EnvInsightsBuiltinProxyImageTag))
+	implementation := getDefault(string(defaults.Implementation), config.GetEnv(common.EnvInsightsProxyImplementation))
+	tokenSource := config.TokenSource
+	if tokenSource == nil {
+		if tokenSecret := config.GetEnv(common.EnvInsightsTokenSecret); len(tokenSecret) > 0 {
+			tokenSource = NewSecretKeyTokenSource(config.Namespace, tokenSecret, common.TokenSecretKey)
+		} else if ssoSecret := config.GetEnv(common.EnvInsightsSSOSecret); len(ssoSecret) > 0 {
+			// Created once, so that access tokens are reused until they are due to expire
+			tokenSource = NewSSOTokenSource(config.Namespace, ssoSecret, config.GetEnv(common.EnvInsightsSSOTokenURL))
+		}
+	}
+	pullSecret := NewPullSecretTokenSource(config.GetEnv(common.EnvInsightsPullSecretNamespace),
+		config.GetEnv(common.EnvInsightsPullSecretName))
+	clusterID := config.GetEnv(common.EnvInsightsClusterID)
+	trustedCAConfigMap := config.GetEnv(common.EnvInsightsTrustedCAConfigMap)
 
 	return &InsightsReconciler{
 		InsightsReconcilerConfig: config,
+		enabled:                  enabled,
 		backendDomain:            backendDomain,
-		proxyDomain:              proxyDomain,
-		proxyImageTag:            imageTag,
+		upstreamProxy:            upstreamProxy,
+		proxyCredentialsSecret:   proxyCredentialsSecret,
+		noProxy:                  noProxy,
+		proxyImageTags:           imageTags,
+		builtinProxyImageTag:     builtinImageTag,
+		implementation:           implementation,
+		tokenSource:              tokenSource,
+		pullSecret:               pullSecret,
+		clusterID:                clusterID,
+		trustedCAConfigMap:       trustedCAConfigMap,
+		clusterDomain:            clusterDomain,
+		ports:                    ports,
+		names:                    names,
 	}, nil
 }
 
+// getDefault returns the value provided programmatically if set,
+// otherwise the value from the environment
+func getDefault(value string, env string) string {
+	if len(value) > 0 {
+		return value
+	}
+	return env
+}
+
+func (r *InsightsReconciler) getAPIReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+func (r *InsightsReconciler) getPlatform() *common.Platform {
+	if r.Platform != nil {
+		return r.Platform
+	}
+	return &common.Platform{}
+}
+
 // +kubebuilder:rbac:namespace=system,groups=apps,resources=deployments;deployments/finalizers,verbs=create;update;get;list;watch
 // +kubebuilder:rbac:namespace=system,groups="",resources=services;secrets;configmaps/finalizers,verbs=create;update;get;list;watch
 // +kubebuilder:rbac:namespace=system,groups="",resources=configmaps,verbs=create;update;delete;get;list;watch
-// +kubebuilder:rbac:groups=config.openshift.io,resources=clusterversions,verbs=get;list;watch
+// +kubebuilder:rbac:namespace=system,groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:namespace=system,groups=runtimes-inventory.redhat.com,resources=insightsproxies,verbs=get;list;watch
+// +kubebuilder:rbac:namespace=system,groups=coordination.k8s.io,resources=leases,verbs=create;update;delete;get;list
+// +kubebuilder:rbac:namespace=system,groups=runtimes-inventory.redhat.com,resources=insightsproxies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=config.openshift.io,resources=clusterversions;proxies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get
 // OLM doesn't let us specify RBAC for openshift-config namespace, so we need a cluster-wide permission
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch,resourceNames=pull-secret
 
@@ -83,50 +247,132 @@ func (r *InsightsReconciler) Reconcile(ctx context.Context, request ctrl.Request
 	reqLogger.Info("Reconciling Insights Proxy")
 
 	// Reconcile all Insights support
-	err := r.reconcileInsights(ctx)
+	result, err := r.reconcileInsights(ctx)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
-	return reconcile.Result{}, nil
+	return result, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *InsightsReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	c := ctrl.NewControllerManagedBy(mgr).
-		Named("insights").
+		Named(r.names.Controller).
+		WithOptions(r.ControllerOptions).
 		// Filter controller to watch only specific objects we care about
 		Watches(&corev1.Secret{},
 			handler.EnqueueRequestsFromMapFunc(r.isPullSecretOrProxyConfig)).
 		Watches(&appsv1.Deployment{},
 			handler.EnqueueRequestsFromMapFunc(r.isProxyDeployment)).
 		Watches(&corev1.Service{},
-			handler.EnqueueRequestsFromMapFunc(r.isProxyService))
+			handler.EnqueueRequestsFromMapFunc(r.isProxyService)).
+		// The proxy is rolled when the CAs it trusts change
+		Watches(&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.isTrustedCABundle)).
+		// Node architectures determine where the proxy may run
+		Watches(&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.isNode),
+			builder.OnlyMetadata,
+			builder.WithPredicates(predicate.LabelChangedPredicate{}))
+
+	// The OpenShift cluster-wide Proxy determines how the backend is reached
+	openshift, err := IsOpenShift(mgr.GetRESTMapper())
+	if err != nil {
+		return err
+	}
+	if openshift {
+		c = c.Watches(&configv1.Proxy{},
+			handler.EnqueueRequestsFromMapFunc(r.isClusterProxy))
+	}
+
+	// Only watch InsightsProxy if its CRD is installed, operators embedding
+	// this controller may not provide it
+	_, err = mgr.GetRESTMapper().RESTMapping(v1alpha1.GroupVersion.WithKind("InsightsProxy").GroupKind(),
+		v1alpha1.GroupVersion.Version)
+	if err == nil {
+		c = c.Watches(&v1alpha1.InsightsProxy{},
+			handler.EnqueueRequestsFromMapFunc(r.isInsightsProxy),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	} else if meta.IsNoMatchError(err) {
+		r.Log.Info("InsightsProxy API not installed, using defaults from environment")
+	} else {
+		return err
+	}
 	return c.Complete(r)
 }
 
 func (r *InsightsReconciler) isPullSecretOrProxyConfig(ctx context.Context, secret client.Object) []reconcile.Request {
-	if !(secret.GetNamespace() == "openshift-config" && secret.GetName() == "pull-secret") &&
-		!(secret.GetNamespace() == r.Namespace && secret.GetName() == common.ProxySecretName) {
+	if !r.isTokenSecret(ctx, secret) && !r.isProxyCredentialsSecret(ctx, secret) &&
+		!(secret.GetNamespace() == r.Namespace && (secret.GetName() == r.names.ProxySecret ||
+			secret.GetName() == r.names.TLSSecret || secret.GetName() == r.names.CASecret)) {
 		return nil
 	}
 	return r.proxyDeploymentRequest()
 }
 
+func (r *InsightsReconciler) isTokenSecret(ctx context.Context, secret client.Object) bool {
+	key := client.ObjectKeyFromObject(secret)
+	if key == r.pullSecret.GetSecret() {
+		return true
+	}
+	if source, ok := r.tokenSource.(SecretTokenSource); ok && source.GetSecret() == key {
+		return true
+	}
+	// The InsightsProxy may name a different Secret
+	tokenSecret, _ := r.proxySecrets.get()
+	return key.Namespace == r.Namespace && len(tokenSecret) > 0 && tokenSecret == key.Name
+}
+
 func (r *InsightsReconciler) isProxyDeployment(ctx context.Context, deploy client.Object) []reconcile.Request {
-	if deploy.GetNamespace() != r.Namespace || deploy.GetName() != common.ProxyDeploymentName {
+	if deploy.GetNamespace() != r.Namespace || deploy.GetName() != r.names.Deployment {
 		return nil
 	}
 	return r.proxyDeploymentRequest()
 }
 
 func (r *InsightsReconciler) isProxyService(ctx context.Context, svc client.Object) []reconcile.Request {
-	if svc.GetNamespace() != r.Namespace || svc.GetName() != common.ProxyServiceName {
+	if svc.GetNamespace() != r.Namespace || svc.GetName() != r.names.Service {
 		return nil
 	}
 	return r.proxyDeploymentRequest()
 }
 
+func (r *InsightsReconciler) isNode(ctx context.Context, node client.Object) []reconcile.Request {
+	return r.proxyDeploymentRequest()
+}
+
+func (r *InsightsReconciler) isInsightsProxy(ctx context.Context, proxy client.Object) []reconcile.Request {
+	if proxy.GetNamespace() != r.Namespace || proxy.GetName() != r.names.InsightsProxy {
+		return nil
+	}
+	return r.proxyDeploymentRequest()
+}
+
+// referencedSecrets holds the names of the Secrets named by the InsightsProxy, recorded when
+// reconciling so that watches need not read the InsightsProxy for every Secret event
+type referencedSecrets struct {
+	mutex                  sync.RWMutex
+	tokenSecret            string
+	proxyCredentialsSecret string
+}
+
+func (s *referencedSecrets) set(proxy *v1alpha1.InsightsProxy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokenSecret, s.proxyCredentialsSecret = "", ""
+	if proxy != nil {
+		s.tokenSecret = proxy.Spec.TokenSecret
+		s.proxyCredentialsSecret = proxy.Spec.UpstreamProxyCredentialsSecret
+	}
+}
+
+func (s *referencedSecrets) get() (tokenSecret string, proxyCredentialsSecret string) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.tokenSecret, s.proxyCredentialsSecret
+}
+
 func (r *InsightsReconciler) proxyDeploymentRequest() []reconcile.Request {
-	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: r.Namespace, Name: common.ProxyDeploymentName}}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: r.Namespace, Name: r.names.Deployment}}
 	return []reconcile.Request{req}
 }
@@ -0,0 +1,129 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "runtimes_inventory"
+	metricsSubsystem = "insights"
+)
+
+// Metric names exported by the Insights controller
+const (
+	MetricInsightsEnabled         = metricsNamespace + "_" + metricsSubsystem + "_enabled"
+	MetricTokenPresent            = metricsNamespace + "_" + metricsSubsystem + "_token_present"
+	MetricProxyAvailableReplicas  = metricsNamespace + "_" + metricsSubsystem + "_proxy_available_replicas"
+	MetricLastSuccessfulReconcile = metricsNamespace + "_" + metricsSubsystem + "_last_successful_reconcile_timestamp_seconds"
+	MetricReconcileErrors         = metricsNamespace + "_" + metricsSubsystem + "_reconcile_errors_total"
+	MetricConfigRotations         = metricsNamespace + "_" + metricsSubsystem + "_config_rotations_total"
+	MetricConnectivity            = metricsNamespace + "_" + metricsSubsystem + "_connectivity"
+)
+
+var (
+	insightsEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "enabled",
+		Help:      "Whether the Insights proxy is enabled (1) or disabled (0)",
+	}, []string{"instance"})
+	tokenPresent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "token_present",
+		Help:      "Whether a token for Red Hat Insights was found (1) or not (0)",
+	}, []string{"instance"})
+	proxyAvailableReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "proxy_available_replicas",
+		Help:      "Number of available replicas of the Insights proxy Deployment",
+	}, []string{"instance"})
+	lastSuccessfulReconcile = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "last_successful_reconcile_timestamp_seconds",
+		Help:      "Unix time of the last successful reconcile of the Insights proxy",
+	}, []string{"instance"})
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "reconcile_errors_total",
+		Help:      "Total number of errors reconciling the Insights proxy, by reason",
+	}, []string{"reason"})
+	configRotations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "config_rotations_total",
+		Help:      "Total number of times the Insights proxy configuration was replaced",
+	})
+	connectivity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "connectivity",
+		Help:      "Whether the last connectivity check through the Insights proxy had this result (1) or not (0)",
+	}, []string{"instance", "result"})
+)
+
+func init() {
+	// Served by the controller-runtime metrics endpoint
+	metrics.Registry.MustRegister(
+		insightsEnabled,
+		tokenPresent,
+		proxyAvailableReplicas,
+		lastSuccessfulReconcile,
+		reconcileErrors,
+		configRotations,
+		connectivity,
+	)
+}
+
+// recordReconcileMetrics updates metrics of the named instance based on the outcome of a reconcile
+func recordReconcileMetrics(instance string, config *proxyConfig, err error) {
+	if config != nil {
+		insightsEnabled.WithLabelValues(instance).Set(boolToFloat(config.enabled))
+		if !config.enabled {
+			// No proxy is deployed
+			tokenPresent.WithLabelValues(instance).Set(0)
+			proxyAvailableReplicas.WithLabelValues(instance).Set(0)
+			recordConnectivityResult(instance, "")
+		}
+	}
+	if err != nil {
+		reconcileErrors.WithLabelValues(reasonForError(err)).Inc()
+	} else {
+		lastSuccessfulReconcile.WithLabelValues(instance).Set(float64(time.Now().Unix()))
+	}
+}
+
+// recordConnectivityResult sets the gauge of the provided result for the named instance,
+// clearing the others
+func recordConnectivityResult(instance string, result ConnectivityResult) {
+	for _, r := range connectivityResults {
+		connectivity.WithLabelValues(instance, string(r)).Set(boolToFloat(r == result))
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
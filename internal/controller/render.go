@@ -0,0 +1,213 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// RedactedToken replaces the token in rendered proxy configuration
+const RedactedToken = "REDACTED"
+
+// RenderConfig contains the inputs used to render the objects managed
+// by the InsightsReconciler, without access to a cluster
+type RenderConfig struct {
+	// Namespace of the operator, where the proxy is deployed
+	Namespace string
+	// Name of the operator's Deployment, used to label the objects
+	OperatorName    string
+	UserAgentPrefix string
+	BackendDomain   string
+	// Image of the proxy, used on amd64 nodes for APICast
+	ProxyImage string
+	// Optional proxy implementation, defaults to APICast
+	Implementation v1alpha1.ProxyImplementation
+	// Optional contents of the OpenShift global pull secret, in .dockerconfigjson format
+	PullSecret []byte
+	// Optional ID of the cluster, as found in its ClusterVersion
+	ClusterID string
+	// Optional environment variables configuring the controller, such as INSIGHTS_PROXY_URL.
+	// The fields above take precedence.
+	Env map[string]string
+	// Optional objects the controller reads from the cluster, such as an InsightsProxy,
+	// the cluster-wide Proxy or a token Secret
+	Objects []client.Object
+	// Whether to include the token in the rendered proxy configuration,
+	// rather than RedactedToken
+	ShowToken bool
+}
+
+// renderEnv provides environment variables from a map in place of the process's
+// environment, so that rendering depends only on its inputs
+type renderEnv map[string]string
+
+func (e renderEnv) GetEnv(name string) string {
+	return e[name]
+}
+
+// Render returns the proxy configuration Secret, Deployment and Service that the InsightsReconciler
+// would create with the provided configuration, on an OpenShift cluster containing only the provided objects.
+// The objects are created by reconciling against an in-memory client, so they match those deployed.
+func Render(ctx context.Context, config *RenderConfig) ([]client.Object, error) {
+	if len(config.Namespace) == 0 || len(config.UserAgentPrefix) == 0 {
+		return nil, errors.New("a namespace and User-Agent prefix are required to render the Insights proxy")
+	}
+	s := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme, configv1.AddToScheme, v1alpha1.AddToScheme,
+	} {
+		if err := addToScheme(s); err != nil {
+			return nil, err
+		}
+	}
+	// Make the OpenShift APIs discoverable, so that the pull secret and service CA are used
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	mapper.Add(clusterVersionKind, meta.RESTScopeRoot)
+	mapper.Add(serviceCAKind, meta.RESTScopeRoot)
+
+	pullSecret := NewPullSecretTokenSource(config.Env[common.EnvInsightsPullSecretNamespace],
+		config.Env[common.EnvInsightsPullSecretName])
+	objs := []client.Object{
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: config.Namespace},
+		},
+	}
+	// Without these, the controller reports what it is missing, unless
+	// the token or cluster ID are provided by other means
+	if len(config.PullSecret) > 0 {
+		objs = append(objs, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pullSecret.GetSecret().Name,
+				Namespace: pullSecret.GetSecret().Namespace,
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: config.PullSecret},
+		})
+	}
+	if len(config.ClusterID) > 0 {
+		objs = append(objs, &configv1.ClusterVersion{
+			ObjectMeta: metav1.ObjectMeta{Name: "version"},
+			Spec:       configv1.ClusterVersionSpec{ClusterID: configv1.ClusterID(config.ClusterID)},
+		})
+	}
+	for _, obj := range config.Objects {
+		obj = obj.DeepCopyObject().(client.Object)
+		obj.SetResourceVersion("")
+		objs = append(objs, obj)
+	}
+	c := fake.NewClientBuilder().WithScheme(s).WithRESTMapper(mapper).WithObjects(objs...).Build()
+
+	enabled := true
+	defaults := ProxyDefaults{
+		Enabled:        &enabled,
+		BackendDomain:  config.BackendDomain,
+		Implementation: config.Implementation,
+	}
+	if config.Implementation == v1alpha1.ProxyImplementationBuiltin {
+		defaults.BuiltinProxyImage = config.ProxyImage
+	} else if len(config.ProxyImage) > 0 {
+		defaults.ProxyImages = map[string]string{archAMD64: config.ProxyImage}
+	}
+	r, err := NewInsightsReconciler(&InsightsReconcilerConfig{
+		Client:          c,
+		Log:             logr.Discard(),
+		Scheme:          s,
+		Namespace:       config.Namespace,
+		OperatorName:    config.OperatorName,
+		UserAgentPrefix: config.UserAgentPrefix,
+		Defaults:        defaults,
+		OSUtils:         renderEnv(config.Env),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The config map is normally created by InsightsIntegration
+	err = c.Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.names.ConfigMap,
+			Namespace: config.Namespace,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.reconcileInsights(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	objs = []client.Object{
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: r.names.ProxySecret}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: r.names.Deployment}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: r.names.Service}},
+	}
+	for _, obj := range objs {
+		err = c.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: config.Namespace}, obj)
+		if err != nil {
+			return nil, err
+		}
+		gvk, err := apiutil.GVKForObject(obj, s)
+		if err != nil {
+			return nil, err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+		// These only have meaning on a cluster
+		obj.SetResourceVersion("")
+		obj.SetOwnerReferences(nil)
+	}
+
+	if !config.ShowToken {
+		var tokenSource TokenSource = r.pullSecret
+		if r.tokenSource != nil {
+			tokenSource = r.tokenSource
+		}
+		token, err := tokenSource.GetToken(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		redactToken(objs[0].(*corev1.Secret), token)
+	}
+	return objs, nil
+}
+
+// redactToken replaces the token within the proxy's configuration
+func redactToken(secret *corev1.Secret, token string) {
+	// The token may be escaped within the JSON configuration
+	escaped, _ := json.Marshal(token)
+	for key, value := range secret.Data {
+		redacted := strings.ReplaceAll(string(value), token, RedactedToken)
+		redacted = strings.ReplaceAll(redacted, strings.Trim(string(escaped), `"`), RedactedToken)
+		secret.Data[key] = []byte(redacted)
+	}
+}
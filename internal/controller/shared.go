@@ -0,0 +1,294 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// SharedProxyLeaseDuration is how long an operator remains the owner or a member
+	// of a shared proxy without renewing its Lease
+	SharedProxyLeaseDuration = 60 * time.Second
+	// Leases are renewed once this fraction of their duration has elapsed
+	leaseRenewFraction = 3
+	// Label on the Leases of the operators using a shared proxy, set to the name of the proxy's Deployment
+	sharedProxyMemberLabel = "runtimes-inventory.redhat.com/shared-proxy-member"
+	// Annotation on a member's Lease containing its User-Agent prefix
+	userAgentPrefixAnnotation = "runtimes-inventory.redhat.com/user-agent-prefix"
+	// Path beneath which each member's clients send requests to the shared proxy
+	sharedProxyMembersPath = "/members"
+)
+
+// SharedProxyConfig configures an InsightsReconciler to share its proxy with the other
+// operators on the cluster configured with the same namespace. The InsightsReconcilerConfig's
+// Namespace is then that of the shared proxy, where the InsightsProxy and any Secrets and
+// config maps named by the environment are also read from.
+type SharedProxyConfig struct {
+	// Namespace of the operator, which together with the OperatorName
+	// identifies it among the operators sharing the proxy
+	OperatorNamespace string
+}
+
+// sharedProxyMember is an operator using a shared proxy
+type sharedProxyMember struct {
+	// Path prefix of the requests of the member's clients
+	pathPrefix      string
+	userAgentPrefix string
+}
+
+// GetSharedProxyMemberPath returns the path prefix the clients of an operator using a shared
+// proxy send their requests beneath, so that they are reported with its own User-Agent prefix
+func GetSharedProxyMemberPath(operatorNamespace string, operatorName string) string {
+	return path.Join(sharedProxyMembersPath, operatorNamespace, operatorName)
+}
+
+// joinSharedProxy renews this operator's membership of the shared proxy, and returns
+// whether it holds the Lease to manage the proxy on behalf of all members. If so, the
+// members and their User-Agent prefixes are added to the configuration.
+func (r *InsightsReconciler) joinSharedProxy(ctx context.Context, config *proxyConfig) (bool, error) {
+	now := metav1.NewMicroTime(time.Now())
+	err := r.renewMemberLease(ctx, now)
+	if err != nil {
+		return false, err
+	}
+	owner, err := r.acquireSharedProxyLease(ctx, now)
+	if err != nil || !owner {
+		return false, err
+	}
+
+	members, err := r.getSharedProxyMembers(ctx, now)
+	if err != nil {
+		return false, err
+	}
+	config.sharedMembers = []sharedProxyMember{}
+	for i := range members {
+		prefix := members[i].Annotations[userAgentPrefixAnnotation]
+		// Identified as <namespace>/<name>, see getSharedProxyIdentity
+		namespace, name, found := strings.Cut(getLeaseHolder(&members[i]), "/")
+		if len(prefix) == 0 || !found {
+			continue
+		}
+		config.sharedMembers = append(config.sharedMembers, sharedProxyMember{
+			pathPrefix:      GetSharedProxyMemberPath(namespace, name),
+			userAgentPrefix: prefix,
+		})
+	}
+	// Sorted so that the proxy's configuration is stable
+	sort.Slice(config.sharedMembers, func(i, j int) bool {
+		return config.sharedMembers[i].pathPrefix < config.sharedMembers[j].pathPrefix
+	})
+	return true, nil
+}
+
+// leaveSharedProxy removes this operator's membership of the shared proxy. If it holds the
+// proxy's Lease, the Lease is released for another member to acquire, or the proxy is deleted
+// if no other members remain.
+func (r *InsightsReconciler) leaveSharedProxy(ctx context.Context) error {
+	now := metav1.NewMicroTime(time.Now())
+	member := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.getMemberLeaseName(),
+			Namespace: r.Namespace,
+		},
+	}
+	err := r.Client.Delete(ctx, member)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+
+	lease := &coordinationv1.Lease{}
+	err = r.getAPIReader().Get(ctx, types.NamespacedName{Name: r.names.Deployment, Namespace: r.Namespace}, lease)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	found := err == nil
+	held := found && getLeaseHolder(lease) == r.getSharedProxyIdentity()
+	// The proxy is abandoned if its owner was uninstalled without releasing the Lease
+	abandoned := !found || isLeaseExpired(lease, now.Time)
+	if !held && !abandoned {
+		return nil
+	}
+
+	members, err := r.getSharedProxyMembers(ctx, now)
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		// No other operator uses the proxy
+		err = r.deleteConfigMap(ctx)
+		if err != nil {
+			return err
+		}
+	} else if !held {
+		return nil
+	}
+	if !found {
+		return nil
+	}
+	err = r.Client.Delete(ctx, lease)
+	if err == nil {
+		r.Log.Info("Released the shared Insights proxy", "name", lease.Name, "namespace", lease.Namespace)
+	}
+	return client.IgnoreNotFound(err)
+}
+
+func (r *InsightsReconciler) renewMemberLease(ctx context.Context, now metav1.MicroTime) error {
+	lease := &coordinationv1.Lease{}
+	err := r.getAPIReader().Get(ctx, types.NamespacedName{Name: r.getMemberLeaseName(), Namespace: r.Namespace}, lease)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	create := kerrors.IsNotFound(err)
+	if create {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      r.getMemberLeaseName(),
+				Namespace: r.Namespace,
+			},
+		}
+	}
+	common.MergeLabelsAndAnnotations(&lease.ObjectMeta, map[string]string{sharedProxyMemberLabel: r.names.Deployment},
+		map[string]string{userAgentPrefixAnnotation: r.UserAgentPrefix})
+	r.renewLease(lease, now)
+	if create {
+		err = r.Client.Create(ctx, lease)
+		if err == nil {
+			r.Log.Info("Joined the shared Insights proxy", "name", r.names.Deployment, "namespace", r.Namespace)
+		}
+		return err
+	}
+	return r.Client.Update(ctx, lease)
+}
+
+// acquireSharedProxyLease returns whether this operator holds the Lease to manage the shared proxy,
+// acquiring it if no other operator holds it, or if the holder failed to renew it
+func (r *InsightsReconciler) acquireSharedProxyLease(ctx context.Context, now metav1.MicroTime) (bool, error) {
+	lease := &coordinationv1.Lease{}
+	err := r.getAPIReader().Get(ctx, types.NamespacedName{Name: r.names.Deployment, Namespace: r.Namespace}, lease)
+	if kerrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      r.names.Deployment,
+				Namespace: r.Namespace,
+			},
+		}
+		r.renewLease(lease, now)
+		lease.Spec.AcquireTime = &now
+		err = r.Client.Create(ctx, lease)
+		if kerrors.IsAlreadyExists(err) {
+			// Another member acquired it first
+			return false, nil
+		}
+		if err == nil {
+			r.Log.Info("Acquired the shared Insights proxy", "name", lease.Name, "namespace", lease.Namespace)
+		}
+		return err == nil, err
+	} else if err != nil {
+		return false, err
+	}
+
+	holder := getLeaseHolder(lease)
+	if holder != r.getSharedProxyIdentity() {
+		if !isLeaseExpired(lease, now.Time) {
+			return false, nil
+		}
+		// The owner failed to renew the Lease, such as because its operator was uninstalled
+		r.Log.Info("Taking over the shared Insights proxy", "name", lease.Name, "namespace", lease.Namespace,
+			"previousOwner", holder)
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions += *lease.Spec.LeaseTransitions
+		}
+		lease.Spec.LeaseTransitions = &transitions
+		lease.Spec.AcquireTime = &now
+	}
+	r.renewLease(lease, now)
+	// Fails if another member updated the Lease since it was read
+	err = r.Client.Update(ctx, lease)
+	if kerrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// getSharedProxyMembers returns the Leases of the shared proxy's members, deleting
+// those of members that failed to renew them
+func (r *InsightsReconciler) getSharedProxyMembers(ctx context.Context, now metav1.MicroTime) ([]coordinationv1.Lease, error) {
+	leases := &coordinationv1.LeaseList{}
+	err := r.getAPIReader().List(ctx, leases, client.InNamespace(r.Namespace),
+		client.MatchingLabels{sharedProxyMemberLabel: r.names.Deployment})
+	if err != nil {
+		return nil, err
+	}
+	members := []coordinationv1.Lease{}
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if !isLeaseExpired(lease, now.Time) {
+			members = append(members, *lease)
+			continue
+		}
+		err = r.Client.Delete(ctx, lease)
+		if client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		r.Log.Info("Removed an expired member of the shared Insights proxy", "member", getLeaseHolder(lease))
+	}
+	return members, nil
+}
+
+func (r *InsightsReconciler) renewLease(lease *coordinationv1.Lease, now metav1.MicroTime) {
+	identity := r.getSharedProxyIdentity()
+	duration := int32(SharedProxyLeaseDuration.Seconds())
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+}
+
+// getSharedProxyIdentity returns the identity of this operator among the members of the shared proxy
+func (r *InsightsReconciler) getSharedProxyIdentity() string {
+	return fmt.Sprintf("%s/%s", r.Shared.OperatorNamespace, r.OperatorName)
+}
+
+func (r *InsightsReconciler) getMemberLeaseName() string {
+	return fmt.Sprintf("%s.%s.%s", r.names.Deployment, r.Shared.OperatorNamespace, r.OperatorName)
+}
+
+func getLeaseHolder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func isLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return lease.Spec.RenewTime.Add(duration).Before(now)
+}
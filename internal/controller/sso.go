@@ -0,0 +1,209 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultSSOTokenURL is the Red Hat SSO endpoint issuing access tokens for console.redhat.com
+const DefaultSSOTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+
+// Client ID used to exchange offline tokens, as issued by console.redhat.com
+const ssoOfflineTokenClientID = "cloud-services"
+
+// Access tokens are refreshed once this fraction of their lifetime has passed
+const ssoRefreshFraction = 0.8
+
+const ssoRequestTimeout = 30 * time.Second
+
+// ExpiringTokenSource is implemented by a TokenSource whose tokens expire.
+// The Insights proxy is reconciled again before the token expires.
+type ExpiringTokenSource interface {
+	TokenSource
+	// RefreshAfter returns how long until the token last returned should be replaced
+	RefreshAfter() time.Duration
+}
+
+// SSOTokenSource exchanges credentials stored in a Secret for short-lived access tokens
+// from Red Hat SSO. The Secret contains either a Red Hat offline token under its
+// "offline_token" key, or the "client_id" and "client_secret" of a console.redhat.com
+// service account.
+type SSOTokenSource struct {
+	secret   types.NamespacedName
+	tokenURL string
+	client   *http.Client
+	now      func() time.Time
+
+	mutex sync.Mutex
+	// Access token obtained for the credentials with this digest
+	credentialsDigest [sha256.Size]byte
+	accessToken       string
+	refreshAt         time.Time
+}
+
+var _ SecretTokenSource = (*SSOTokenSource)(nil)
+var _ ExpiringTokenSource = (*SSOTokenSource)(nil)
+
+// NewSSOTokenSource creates an SSOTokenSource for the Secret with the provided namespace
+// and name. An empty token URL defaults to the production Red Hat SSO token endpoint.
+func NewSSOTokenSource(namespace string, name string, tokenURL string) *SSOTokenSource {
+	if len(tokenURL) == 0 {
+		tokenURL = DefaultSSOTokenURL
+	}
+	return &SSOTokenSource{
+		secret:   types.NamespacedName{Namespace: namespace, Name: name},
+		tokenURL: tokenURL,
+		client:   &http.Client{Timeout: ssoRequestTimeout},
+		now:      time.Now,
+	}
+}
+
+// GetToken returns an access token for the credentials in the Secret, requesting
+// a new one from Red Hat SSO if the credentials changed or the token is due to expire
+func (s *SSOTokenSource) GetToken(ctx context.Context, reader client.Reader) (string, error) {
+	secret := &corev1.Secret{}
+	err := reader.Get(ctx, s.secret, secret)
+	if err != nil {
+		return "", NewTokenError(v1alpha1.ReasonTokenSecretUnavailable, err)
+	}
+	form, err := s.getTokenRequest(secret)
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	digest := sha256.Sum256([]byte(form.Encode()))
+	if digest == s.credentialsDigest && len(s.accessToken) > 0 && s.now().Before(s.refreshAt) {
+		return s.accessToken, nil
+	}
+
+	issued := s.now()
+	token, expiresIn, err := s.requestToken(ctx, form)
+	if err != nil {
+		return "", NewTokenError(v1alpha1.ReasonTokenExchangeFailed,
+			fmt.Errorf("failed to obtain an access token from %s: %w", s.tokenURL, err))
+	}
+	s.credentialsDigest = digest
+	s.accessToken = token
+	s.refreshAt = issued.Add(time.Duration(float64(expiresIn) * ssoRefreshFraction))
+	return token, nil
+}
+
+// RefreshAfter returns how long until the current access token should be refreshed
+func (s *SSOTokenSource) RefreshAfter() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	refreshAfter := s.refreshAt.Sub(s.now())
+	if refreshAfter < time.Second {
+		return time.Second
+	}
+	return refreshAfter
+}
+
+// GetSecret returns the location of the Secret containing the credentials
+func (s *SSOTokenSource) GetSecret() types.NamespacedName {
+	return s.secret
+}
+
+func (s *SSOTokenSource) String() string {
+	return fmt.Sprintf("Red Hat SSO using Secret %s", s.secret.Name)
+}
+
+// getTokenRequest returns the form to request an access token for the credentials in the Secret
+func (s *SSOTokenSource) getTokenRequest(secret *corev1.Secret) (url.Values, error) {
+	if offlineToken := strings.TrimSpace(string(secret.Data[common.SSOOfflineTokenKey])); len(offlineToken) > 0 {
+		return url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {ssoOfflineTokenClientID},
+			"refresh_token": {offlineToken},
+		}, nil
+	}
+	clientID := strings.TrimSpace(string(secret.Data[common.SSOClientIDKey]))
+	clientSecret := strings.TrimSpace(string(secret.Data[common.SSOClientSecretKey]))
+	if len(clientID) > 0 && len(clientSecret) > 0 {
+		return url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {clientID},
+			"client_secret": {clientSecret},
+		}, nil
+	}
+	return nil, NewTokenError(v1alpha1.ReasonTokenMissing,
+		fmt.Errorf("Secret %s must contain either an %s key, or both %s and %s keys", s.secret.Name,
+			common.SSOOfflineTokenKey, common.SSOClientIDKey, common.SSOClientSecretKey))
+}
+
+// requestToken returns an access token and its lifetime from the token endpoint
+func (s *SSOTokenSource) requestToken(ctx context.Context, form url.Values) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+
+	tokenResp := struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	err = json.Unmarshal(body, &tokenResp)
+	if resp.StatusCode != http.StatusOK {
+		// Prefer the OAuth error in the response, if there is one
+		if err == nil && len(tokenResp.Error) > 0 {
+			return "", 0, fmt.Errorf("%s (%s): %s", resp.Status, tokenResp.Error, tokenResp.ErrorDescription)
+		}
+		return "", 0, errors.New(resp.Status)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	token, err := validateToken(tokenResp.AccessToken, "invalid access token in response")
+	if err != nil {
+		return "", 0, err
+	}
+	if tokenResp.ExpiresIn <= 0 {
+		return "", 0, errors.New("no expiry for access token in response")
+	}
+	return token, time.Duration(tokenResp.ExpiresIn) * time.Second, nil
+}
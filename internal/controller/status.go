@@ -0,0 +1,154 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileError is an error encountered while reconciling the Insights proxy,
+// along with a machine-readable reason describing what failed
+type reconcileError struct {
+	reason string
+	err    error
+}
+
+func newReconcileError(reason string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &reconcileError{reason: reason, err: err}
+}
+
+func (e *reconcileError) Error() string {
+	return e.err.Error()
+}
+
+func (e *reconcileError) Unwrap() error {
+	return e.err
+}
+
+// reasonForError returns the reason associated with an error
+// returned while reconciling
+func reasonForError(err error) string {
+	var reconcileErr *reconcileError
+	if errors.As(err, &reconcileErr) {
+		return reconcileErr.reason
+	}
+	return v1alpha1.ReasonReconcileFailed
+}
+
+// insightsStatus accumulates conditions observed during a single reconcile
+type insightsStatus struct {
+	conditions []metav1.Condition
+}
+
+func (s *insightsStatus) setCondition(condType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&s.conditions, metav1.Condition{
+		Type:    condType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// setResult sets the Ready and Degraded conditions based on the
+// outcome of the reconcile
+func (s *insightsStatus) setResult(err error) {
+	if err != nil {
+		reason := reasonForError(err)
+		s.setCondition(v1alpha1.ConditionTypeDegraded, metav1.ConditionTrue, reason, err.Error())
+		s.setCondition(v1alpha1.ConditionTypeReady, metav1.ConditionFalse, reason, err.Error())
+		return
+	}
+	s.setCondition(v1alpha1.ConditionTypeDegraded, metav1.ConditionFalse, v1alpha1.ReasonReconciled,
+		"The Insights proxy was reconciled successfully")
+
+	available := meta.FindStatusCondition(s.conditions, v1alpha1.ConditionTypeProxyAvailable)
+	if available != nil && available.Status == metav1.ConditionTrue {
+		s.setCondition(v1alpha1.ConditionTypeReady, metav1.ConditionTrue, v1alpha1.ReasonReconciled,
+			"The Insights proxy is ready to forward reports")
+	} else {
+		s.setCondition(v1alpha1.ConditionTypeReady, metav1.ConditionFalse, v1alpha1.ReasonDeploymentUnavailable,
+			"The Insights proxy is not yet available")
+	}
+}
+
+// applyTo merges the accumulated conditions into an existing list of conditions,
+// preserving transition times for conditions whose status has not changed
+func (s *insightsStatus) applyTo(conditions *[]metav1.Condition, generation int64) {
+	for _, condition := range s.conditions {
+		condition.ObservedGeneration = generation
+		meta.SetStatusCondition(conditions, condition)
+	}
+}
+
+// GetConfigMapConditions returns the status conditions stored in the Insights config map
+func GetConfigMapConditions(cm *corev1.ConfigMap) ([]metav1.Condition, error) {
+	conditions := []metav1.Condition{}
+	raw, pres := cm.Data[common.InsightsConditionsKey]
+	if !pres {
+		return conditions, nil
+	}
+	err := json.Unmarshal([]byte(raw), &conditions)
+	if err != nil {
+		return nil, err
+	}
+	return conditions, nil
+}
+
+func (r *InsightsReconciler) updateConfigMapStatus(ctx context.Context, status *insightsStatus) error {
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.ConfigMap,
+		Namespace: r.Namespace}, cm)
+	if err != nil {
+		// Nothing to update if the config map could not be created
+		return client.IgnoreNotFound(err)
+	}
+
+	conditions, err := GetConfigMapConditions(cm)
+	if err != nil {
+		// Replace unreadable conditions
+		r.Log.Error(err, "failed to parse conditions from config map", "name", cm.Name, "namespace", cm.Namespace)
+		conditions = []metav1.Condition{}
+	}
+	updated := make([]metav1.Condition, len(conditions))
+	copy(updated, conditions)
+	status.applyTo(&updated, 0)
+	if equality.Semantic.DeepEqual(conditions, updated) {
+		return nil
+	}
+
+	raw, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[common.InsightsConditionsKey] = string(raw)
+	return r.Client.Update(ctx, cm)
+}
@@ -0,0 +1,90 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// FakeInsightsBackend is a stand-in for the Insights proxy and the Red Hat Insights
+// server behind it, serving TLS with the proxy's certificate and responding to
+// every request with StatusCode and Header
+type FakeInsightsBackend struct {
+	*httptest.Server
+	// Status of the responses, 415 Unsupported Media Type by default
+	// as returned by Red Hat Insights for an empty upload
+	StatusCode int
+	// Headers of the responses
+	Header http.Header
+	// Method and path of the requests received, in order
+	Requests []string
+	mutex    sync.Mutex
+}
+
+// NewFakeInsightsBackend starts a FakeInsightsBackend using the certificate in the
+// provided TLS secret, which should be closed when no longer needed
+func NewFakeInsightsBackend(serving *corev1.Secret) *FakeInsightsBackend {
+	cert, err := tls.X509KeyPair(serving.Data[corev1.TLSCertKey], serving.Data[corev1.TLSPrivateKeyKey])
+	gomega.ExpectWithOffset(1, err).ToNot(gomega.HaveOccurred())
+	b := &FakeInsightsBackend{StatusCode: http.StatusUnsupportedMediaType, Header: http.Header{}}
+	b.Server = httptest.NewUnstartedServer(http.HandlerFunc(b.handle))
+	b.Server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	b.Server.StartTLS()
+	return b
+}
+
+// DialContext connects to the FakeInsightsBackend regardless of the address requested
+func (b *FakeInsightsBackend) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, b.Listener.Addr().String())
+}
+
+// SetStatusCode sets the status of subsequent responses
+func (b *FakeInsightsBackend) SetStatusCode(statusCode int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.StatusCode = statusCode
+}
+
+// SetHeader sets a header of subsequent responses
+func (b *FakeInsightsBackend) SetHeader(key string, value string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Header.Set(key, value)
+}
+
+// GetRequests returns the method and path of the requests received so far
+func (b *FakeInsightsBackend) GetRequests() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string{}, b.Requests...)
+}
+
+func (b *FakeInsightsBackend) handle(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Requests = append(b.Requests, r.Method+" "+r.URL.Path)
+	for key, values := range b.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(b.StatusCode)
+}
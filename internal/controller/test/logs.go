@@ -0,0 +1,66 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+// FakePodLogs returns the same logs for every container, as the fake
+// clientset's pods only ever return "fake logs"
+type FakePodLogs struct {
+	Logs string
+}
+
+var _ corev1client.PodsGetter = (*FakePodLogs)(nil)
+
+func (f *FakePodLogs) Pods(namespace string) corev1client.PodInterface {
+	return &fakePodLogsInterface{
+		PodInterface: fake.NewSimpleClientset().CoreV1().Pods(namespace),
+		namespace:    namespace,
+		logs:         f.Logs,
+	}
+}
+
+type fakePodLogsInterface struct {
+	corev1client.PodInterface
+	namespace string
+	logs      string
+}
+
+func (f *fakePodLogsInterface) GetLogs(name string, opts *corev1.PodLogOptions) *rest.Request {
+	client := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(f.logs)),
+			}, nil
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         corev1.SchemeGroupVersion,
+		VersionedAPIPath:     fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", f.namespace, name),
+	}
+	return client.Request()
+}
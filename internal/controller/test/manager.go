@@ -16,7 +16,10 @@ package test
 
 import (
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -26,18 +29,22 @@ import (
 
 type FakeManager struct {
 	ctrl.Manager
-	client client.Client
-	scheme *runtime.Scheme
-	logger *logr.Logger
+	client   client.Client
+	scheme   *runtime.Scheme
+	logger   *logr.Logger
+	Recorder *record.FakeRecorder
+	// Config for the test environment's API server, used for discovery
+	Config *rest.Config
 }
 
 var _ ctrl.Manager = &FakeManager{}
 
 func NewFakeManager(client client.Client, scheme *runtime.Scheme, logger *logr.Logger) *FakeManager {
 	return &FakeManager{
-		client: client,
-		scheme: scheme,
-		logger: logger,
+		client:   client,
+		scheme:   scheme,
+		logger:   logger,
+		Recorder: record.NewFakeRecorder(100),
 	}
 }
 
@@ -45,6 +52,10 @@ func (m *FakeManager) GetCache() cache.Cache {
 	return nil
 }
 
+func (m *FakeManager) GetConfig() *rest.Config {
+	return m.Config
+}
+
 func (m *FakeManager) GetClient() client.Client {
 	return m.client
 }
@@ -53,6 +64,14 @@ func (m *FakeManager) GetScheme() *runtime.Scheme {
 	return m.scheme
 }
 
+func (m *FakeManager) GetRESTMapper() meta.RESTMapper {
+	return m.client.RESTMapper()
+}
+
+func (m *FakeManager) GetEventRecorderFor(name string) record.EventRecorder {
+	return m.Recorder
+}
+
 func (m *FakeManager) GetAPIReader() client.Reader {
 	// May need to change if not using a fake client
 	return m.client
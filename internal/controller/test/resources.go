@@ -16,9 +16,12 @@ package test
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
 	configv1 "github.com/openshift/api/config/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,17 +29,24 @@ import (
 )
 
 type InsightsTestResources struct {
-	Namespace       string
-	UserAgentPrefix string
-	Resources       *corev1.ResourceRequirements
+	Namespace        string
+	UserAgentPrefix  string
+	Resources        *corev1.ResourceRequirements
+	PodSecurityLevel string
 }
 
 func (r *InsightsTestResources) NewNamespace() *corev1.Namespace {
-	return &corev1.Namespace{
+	ns := &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: r.Namespace,
 		},
 	}
+	if len(r.PodSecurityLevel) > 0 {
+		ns.Labels = map[string]string{
+			"pod-security.kubernetes.io/enforce": r.PodSecurityLevel,
+		}
+	}
+	return ns
 }
 
 func (r *InsightsTestResources) NewGlobalPullSecret() *corev1.Secret {
@@ -52,6 +62,42 @@ func (r *InsightsTestResources) NewGlobalPullSecret() *corev1.Secret {
 	}
 }
 
+func (r *InsightsTestResources) NewTokenSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "insights-token",
+			Namespace: r.Namespace,
+		},
+		Data: map[string][]byte{
+			"token": []byte("world\n"),
+		},
+	}
+}
+
+func (r *InsightsTestResources) NewKubeSystemNamespace() *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "kube-system",
+			UID:  "abcde",
+		},
+	}
+}
+
+// NewHostedPullSecret returns a pull secret in the operator's namespace,
+// as provided for hosted control planes
+func (r *InsightsTestResources) NewHostedPullSecret() *corev1.Secret {
+	secret := r.NewGlobalPullSecret()
+	secret.Name = "hosted-pull-secret"
+	secret.Namespace = r.Namespace
+	return secret
+}
+
+func (r *InsightsTestResources) NewGlobalPullSecretWithoutInsightsAuth() *corev1.Secret {
+	secret := r.NewGlobalPullSecret()
+	secret.Data[corev1.DockerConfigJsonKey] = []byte(`{"auths":{"example.com":{"auth":"hello"}}}`)
+	return secret
+}
+
 func (r *InsightsTestResources) NewOperatorDeployment() *appsv1.Deployment {
 	return &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -83,6 +129,15 @@ func (r *InsightsTestResources) NewOperatorDeployment() *appsv1.Deployment {
 	}
 }
 
+func (r *InsightsTestResources) NewProxyLabels() map[string]string {
+	return map[string]string{
+		"app":                          "insights-proxy",
+		"app.kubernetes.io/name":       "insights-proxy",
+		"app.kubernetes.io/instance":   "insights-proxy",
+		"app.kubernetes.io/managed-by": r.NewOperatorDeployment().Name,
+	}
+}
+
 func (r *InsightsTestResources) NewProxyConfigMap() *corev1.ConfigMap {
 	return &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -97,6 +152,7 @@ func (r *InsightsTestResources) NewInsightsProxySecret() *corev1.Secret {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "apicastconf",
 			Namespace: r.Namespace,
+			Labels:    r.NewProxyLabels(),
 		},
 		StringData: map[string]string{
 			"config.json": fmt.Sprintf(`{
@@ -160,10 +216,15 @@ func (r *InsightsTestResources) NewInsightsProxySecret() *corev1.Secret {
 }
 
 func (r *InsightsTestResources) NewInsightsProxySecretWithProxyDomain() *corev1.Secret {
+	return r.NewInsightsProxySecretWithProxyURL("http://proxy.example.com")
+}
+
+func (r *InsightsTestResources) NewInsightsProxySecretWithProxyURL(proxyURL string) *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "apicastconf",
 			Namespace: r.Namespace,
+			Labels:    r.NewProxyLabels(),
 		},
 		StringData: map[string]string{
 			"config.json": fmt.Sprintf(`{
@@ -187,8 +248,8 @@ func (r *InsightsTestResources) NewInsightsProxySecretWithProxyDomain() *corev1.
 						{
 						  "name": "apicast.policy.http_proxy",
 						  "configuration": {
-						    "https_proxy": "http://proxy.example.com/",
-						    "http_proxy": "http://proxy.example.com/"
+						    "https_proxy": "%s/",
+						    "http_proxy": "%s/"
 						  }
 						},
 						{
@@ -228,7 +289,7 @@ func (r *InsightsTestResources) NewInsightsProxySecretWithProxyDomain() *corev1.
 					}
 				  }
 				]
-			  }`, r.Namespace, r.UserAgentPrefix),
+			  }`, r.Namespace, proxyURL, proxyURL, r.UserAgentPrefix),
 		},
 	}
 }
@@ -253,9 +314,7 @@ func (r *InsightsTestResources) NewInsightsProxyDeployment() *appsv1.Deployment
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "insights-proxy",
 			Namespace: r.Namespace,
-			Labels: map[string]string{
-				"app": "insights-proxy",
-			},
+			Labels:    r.NewProxyLabels(),
 		},
 		Spec: appsv1.DeploymentSpec{
 			Selector: &metav1.LabelSelector{
@@ -279,6 +338,18 @@ func (r *InsightsTestResources) NewInsightsProxyDeployment() *appsv1.Deployment
 									Name:  "THREESCALE_CONFIG_FILE",
 									Value: "/tmp/gateway-configuration-volume/config.json",
 								},
+								{
+									Name:  "APICAST_HTTPS_PORT",
+									Value: "8443",
+								},
+								{
+									Name:  "APICAST_HTTPS_CERTIFICATE",
+									Value: "/var/run/secrets/insights-proxy/tls/tls.crt",
+								},
+								{
+									Name:  "APICAST_HTTPS_CERTIFICATE_KEY",
+									Value: "/var/run/secrets/insights-proxy/tls/tls.key",
+								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
 								{
@@ -286,6 +357,11 @@ func (r *InsightsTestResources) NewInsightsProxyDeployment() *appsv1.Deployment
 									MountPath: "/tmp/gateway-configuration-volume",
 									ReadOnly:  true,
 								},
+								{
+									Name:      "tls-secret",
+									MountPath: "/var/run/secrets/insights-proxy/tls",
+									ReadOnly:  true,
+								},
 							},
 							Ports: []corev1.ContainerPort{
 								{
@@ -293,6 +369,11 @@ func (r *InsightsTestResources) NewInsightsProxyDeployment() *appsv1.Deployment
 									Protocol:      corev1.ProtocolTCP,
 									ContainerPort: 8080,
 								},
+								{
+									Name:          "https",
+									Protocol:      corev1.ProtocolTCP,
+									ContainerPort: 8443,
+								},
 								{
 									Name:          "management",
 									Protocol:      corev1.ProtocolTCP,
@@ -358,24 +439,122 @@ func (r *InsightsTestResources) NewInsightsProxyDeployment() *appsv1.Deployment
 								},
 							},
 						},
+						{
+							Name: "tls-secret",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName:  "insights-proxy-tls",
+									DefaultMode: &[]int32{0440}[0],
+								},
+							},
+						},
 					},
 					SecurityContext: &corev1.PodSecurityContext{
 						RunAsNonRoot: &[]bool{true}[0],
+						RunAsUser:    &[]int64{1001}[0],
+						FSGroup:      &[]int64{1001}[0],
+						SeccompProfile: &corev1.SeccompProfile{
+							Type: corev1.SeccompProfileTypeRuntimeDefault,
+						},
 					},
+					Affinity: NewArchitectureAffinity("amd64"),
 				},
 			},
 		},
 	}
 }
 
+func (r *InsightsTestResources) NewBuiltinProxySecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "apicastconf",
+			Namespace: r.Namespace,
+			Labels:    r.NewProxyLabels(),
+		},
+		StringData: map[string]string{
+			"config.json": fmt.Sprintf(`{
+				"backendURL": "https://insights.example.com",
+				"token": "world",
+				"userAgent": "%s cluster/abcde",
+				"allowedMethods": ["POST"],
+				"allowedPaths": ["/api/ingress/v1/upload"]
+			  }`, r.UserAgentPrefix),
+		},
+	}
+}
+
+func (r *InsightsTestResources) NewBuiltinProxyDeployment() *appsv1.Deployment {
+	deploy := r.NewInsightsProxyDeployment()
+	container := &deploy.Spec.Template.Spec.Containers[0]
+	container.Image = "example.com/operator:latest"
+	container.Args = []string{
+		"proxy",
+		"--config=/tmp/gateway-configuration-volume/config.json",
+		"--tls-cert-file=/var/run/secrets/insights-proxy/tls/tls.crt",
+		"--tls-key-file=/var/run/secrets/insights-proxy/tls/tls.key",
+	}
+	container.Env = nil
+	container.Ports = []corev1.ContainerPort{
+		{
+			Name:          "https",
+			Protocol:      corev1.ProtocolTCP,
+			ContainerPort: 8443,
+		},
+		{
+			Name:          "management",
+			Protocol:      corev1.ProtocolTCP,
+			ContainerPort: 8090,
+		},
+		{
+			Name:          "metrics",
+			Protocol:      corev1.ProtocolTCP,
+			ContainerPort: 9421,
+		},
+	}
+	deploy.Spec.Template.Spec.Affinity = NewArchitectureAffinity("amd64", "arm64", "ppc64le", "s390x")
+	return deploy
+}
+
+// NewArchitectureAffinity returns the node affinity expected for a proxy image
+// supporting the provided architectures
+func NewArchitectureAffinity(archs ...string) *corev1.Affinity {
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      "kubernetes.io/arch",
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   archs,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (r *InsightsTestResources) NewNode(name string, arch string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"kubernetes.io/arch": arch,
+				"kubernetes.io/os":   "linux",
+			},
+		},
+	}
+}
+
 func (r *InsightsTestResources) NewInsightsProxyService() *corev1.Service {
 	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "insights-proxy",
 			Namespace: r.Namespace,
-			Labels: map[string]string{
-				"app": "insights-proxy",
-			},
+			Labels:    r.NewProxyLabels(),
 		},
 		Spec: corev1.ServiceSpec{
 			Type: corev1.ServiceTypeClusterIP,
@@ -386,8 +565,8 @@ func (r *InsightsTestResources) NewInsightsProxyService() *corev1.Service {
 				{
 					Name:       "proxy",
 					Protocol:   corev1.ProtocolTCP,
-					Port:       8080,
-					TargetPort: intstr.FromString("proxy"),
+					Port:       8443,
+					TargetPort: intstr.FromString("https"),
 				},
 				{
 					Name:       "management",
@@ -410,3 +589,128 @@ func (r *InsightsTestResources) NewClusterVersion() *configv1.ClusterVersion {
 		},
 	}
 }
+
+// NewUserCABundle returns a config map with a CA bundle for the proxy to trust,
+// provided by the user
+func (r *InsightsTestResources) NewUserCABundle() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "corporate-ca",
+			Namespace: r.Namespace,
+		},
+		Data: map[string]string{
+			"ca-bundle.crt": "user CA bundle",
+		},
+	}
+}
+
+// AddTrustedCABundle updates an expected proxy Deployment to trust the CA bundle
+// in the insights-proxy-trusted-ca config map
+func (r *InsightsTestResources) AddTrustedCABundle(deploy *appsv1.Deployment) {
+	readOnlyMode := int32(0444)
+	podSpec := &deploy.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      "trusted-ca",
+		MountPath: "/etc/pki/ca-trust/extracted/pem",
+		ReadOnly:  true,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "trusted-ca",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: "insights-proxy-trusted-ca",
+				},
+				Items: []corev1.KeyToPath{
+					{
+						Key:  "ca-bundle.crt",
+						Path: "tls-ca-bundle.pem",
+					},
+				},
+				DefaultMode: &readOnlyMode,
+			},
+		},
+	})
+}
+
+// NewClusterProxy returns an OpenShift cluster-wide Proxy, whose status
+// must be updated separately from its creation
+func (r *InsightsTestResources) NewClusterProxy() *configv1.Proxy {
+	return &configv1.Proxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster",
+		},
+		Spec: configv1.ProxySpec{
+			HTTPProxy:  "http://cluster-proxy.example.com:3128",
+			HTTPSProxy: "http://cluster-proxy.example.com:3128",
+		},
+		Status: configv1.ProxyStatus{
+			HTTPProxy:  "http://cluster-proxy.example.com:3128",
+			HTTPSProxy: "http://cluster-proxy.example.com:3128",
+			NoProxy:    ".cluster.local,.svc,10.0.0.0/16,localhost",
+		},
+	}
+}
+
+// NewProxyCredentialsSecret returns a Secret containing credentials for the upstream proxy
+func (r *InsightsTestResources) NewProxyCredentialsSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "proxy-credentials",
+			Namespace: r.Namespace,
+		},
+		Type: corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte("proxy-user"),
+			corev1.BasicAuthPasswordKey: []byte("p@ss\n"),
+		},
+	}
+}
+
+func (r *InsightsTestResources) NewInsightsProxy() *v1alpha1.InsightsProxy {
+	return &v1alpha1.InsightsProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "insights-proxy",
+			Namespace: r.Namespace,
+		},
+	}
+}
+
+// NewSharedProxyLease returns the Lease held by the operator managing a shared proxy
+func (r *InsightsTestResources) NewSharedProxyLease(holder string, renewed time.Time) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "insights-proxy",
+			Namespace: r.Namespace,
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &[]int32{60}[0],
+			RenewTime:            &metav1.MicroTime{Time: renewed},
+		},
+	}
+}
+
+// NewSharedProxyMemberLease returns the Lease of another operator using a shared proxy
+func (r *InsightsTestResources) NewSharedProxyMemberLease(namespace string, name string, userAgentPrefix string,
+	renewed time.Time) *coordinationv1.Lease {
+	holder := namespace + "/" + name
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("insights-proxy.%s.%s", namespace, name),
+			Namespace: r.Namespace,
+			Labels: map[string]string{
+				"runtimes-inventory.redhat.com/shared-proxy-member": "insights-proxy",
+			},
+			Annotations: map[string]string{
+				"runtimes-inventory.redhat.com/user-agent-prefix": userAgentPrefix,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &[]int32{60}[0],
+			RenewTime:            &metav1.MicroTime{Time: renewed},
+		},
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Credentials accepted by the FakeSSOServer
+const (
+	TestOfflineToken = "offline-token"
+	TestClientID     = "service-account"
+	TestClientSecret = "service-account-secret"
+)
+
+// FakeSSOServer is a stand-in for the Red Hat SSO token endpoint, issuing
+// access tokens "access-token-1", "access-token-2", etc. for valid credentials
+type FakeSSOServer struct {
+	*httptest.Server
+	// Lifetime in seconds of issued access tokens
+	ExpiresIn int
+	// Requests received, in order
+	Requests []url.Values
+	mutex    sync.Mutex
+}
+
+// NewFakeSSOServer starts a FakeSSOServer, which should be closed when no longer needed
+func NewFakeSSOServer() *FakeSSOServer {
+	s := &FakeSSOServer{ExpiresIn: 900}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handleToken))
+	return s
+}
+
+// GetRequests returns the token requests received so far
+func (s *FakeSSOServer) GetRequests() []url.Values {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]url.Values{}, s.Requests...)
+}
+
+func (s *FakeSSOServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.Requests = append(s.Requests, r.PostForm)
+
+	form := r.PostForm
+	valid := false
+	switch form.Get("grant_type") {
+	case "refresh_token":
+		valid = form.Get("client_id") == "cloud-services" && form.Get("refresh_token") == TestOfflineToken
+	case "client_credentials":
+		valid = form.Get("client_id") == TestClientID && form.Get("client_secret") == TestClientSecret
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "Invalid credentials",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": fmt.Sprintf("access-token-%d", len(s.Requests)),
+		"token_type":   "Bearer",
+		"expires_in":   s.ExpiresIn,
+	})
+}
+
+// NewOfflineTokenSecret returns a Secret containing a Red Hat offline token
+func (r *InsightsTestResources) NewOfflineTokenSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "insights-sso",
+			Namespace: r.Namespace,
+		},
+		Data: map[string][]byte{
+			"offline_token": []byte(TestOfflineToken),
+		},
+	}
+}
+
+// NewServiceAccountSecret returns a Secret containing the credentials of a console.redhat.com service account
+func (r *InsightsTestResources) NewServiceAccountSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "insights-sso",
+			Namespace: r.Namespace,
+		},
+		Data: map[string][]byte{
+			"client_id":     []byte(TestClientID),
+			"client_secret": []byte(TestClientSecret),
+		},
+	}
+}
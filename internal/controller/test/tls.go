@@ -0,0 +1,110 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NewProxyTLSSecrets returns a CA secret and a serving certificate secret for the proxy
+// signed by that CA, where the serving certificate was issued 30 days ago and remains
+// valid for the provided duration
+func (r *InsightsTestResources) NewProxyTLSSecrets(validity time.Duration) (ca *corev1.Secret, serving *corev1.Secret) {
+	return r.NewProxyTLSSecretsWithCAValidity(365*24*time.Hour, validity)
+}
+
+// NewProxyTLSSecretsWithCAValidity is like NewProxyTLSSecrets, where the CA was also
+// issued 30 days ago and remains valid for the provided duration
+func (r *InsightsTestResources) NewProxyTLSSecretsWithCAValidity(caValidity time.Duration,
+	validity time.Duration) (ca *corev1.Secret, serving *corev1.Secret) {
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             now.Add(-30 * 24 * time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caCert, caKey := newTestCertificate(caTemplate, nil, nil)
+
+	servingTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "insights-proxy"},
+		DNSNames: []string{
+			"insights-proxy",
+			fmt.Sprintf("insights-proxy.%s", r.Namespace),
+			fmt.Sprintf("insights-proxy.%s.svc", r.Namespace),
+			fmt.Sprintf("insights-proxy.%s.svc.cluster.local", r.Namespace),
+		},
+		NotBefore:   now.Add(-30 * 24 * time.Hour),
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	servingCert, servingKey := newTestCertificate(servingTemplate, caCert, caKey)
+
+	return newTLSSecret("insights-proxy-ca", r.Namespace, caCert, caKey),
+		newTLSSecret("insights-proxy-tls", r.Namespace, servingCert, servingKey)
+}
+
+// ParseCertificate parses the PEM-encoded certificate in a TLS secret
+func ParseCertificate(secret *corev1.Secret) *x509.Certificate {
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	gomega.ExpectWithOffset(1, block).ToNot(gomega.BeNil())
+	cert, err := x509.ParseCertificate(block.Bytes)
+	gomega.ExpectWithOffset(1, err).ToNot(gomega.HaveOccurred())
+	return cert
+}
+
+func newTestCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	return cert, key
+}
+
+func newTLSSecret(name string, namespace string, cert *x509.Certificate, key *rsa.PrivateKey) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+	}
+}
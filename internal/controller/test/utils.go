@@ -20,10 +20,23 @@ import (
 
 // TestUtilsConfig groups parameters used to create a test OSUtils
 type TestUtilsConfig struct {
-	EnvInsightsEnabled       *bool
-	EnvInsightsProxyImageTag *string
-	EnvInsightsBackendDomain *string
-	EnvInsightsProxyDomain   *string
+	EnvInsightsEnabled              *bool
+	EnvInsightsProxyImageTag        *string
+	EnvInsightsBackendDomain        *string
+	EnvInsightsProxyDomain          *string
+	EnvInsightsProxyImplementation  *string
+	EnvInsightsBuiltinProxyImageTag *string
+	EnvInsightsProxyImageTagARM64   *string
+	EnvInsightsTokenSecret          *string
+	EnvInsightsClusterID            *string
+	EnvInsightsPullSecretNamespace  *string
+	EnvInsightsPullSecretName       *string
+	EnvInsightsSSOSecret            *string
+	EnvInsightsSSOTokenURL          *string
+	EnvInsightsTrustedCAConfigMap   *string
+	EnvInsightsProxyURL             *string
+	EnvInsightsProxyCredentials     *string
+	EnvInsightsNoProxy              *string
 }
 
 type testOSUtils struct {
@@ -44,6 +57,45 @@ func NewTestOSUtils(config *TestUtilsConfig) *testOSUtils {
 	if config.EnvInsightsProxyDomain != nil {
 		envs["INSIGHTS_PROXY_DOMAIN"] = *config.EnvInsightsProxyDomain
 	}
+	if config.EnvInsightsProxyImplementation != nil {
+		envs["INSIGHTS_PROXY_IMPLEMENTATION"] = *config.EnvInsightsProxyImplementation
+	}
+	if config.EnvInsightsBuiltinProxyImageTag != nil {
+		envs["RELATED_IMAGE_INSIGHTS_BUILTIN_PROXY"] = *config.EnvInsightsBuiltinProxyImageTag
+	}
+	if config.EnvInsightsProxyImageTagARM64 != nil {
+		envs["RELATED_IMAGE_INSIGHTS_PROXY_ARM64"] = *config.EnvInsightsProxyImageTagARM64
+	}
+	if config.EnvInsightsTokenSecret != nil {
+		envs["INSIGHTS_TOKEN_SECRET"] = *config.EnvInsightsTokenSecret
+	}
+	if config.EnvInsightsClusterID != nil {
+		envs["INSIGHTS_CLUSTER_ID"] = *config.EnvInsightsClusterID
+	}
+	if config.EnvInsightsPullSecretNamespace != nil {
+		envs["INSIGHTS_PULL_SECRET_NAMESPACE"] = *config.EnvInsightsPullSecretNamespace
+	}
+	if config.EnvInsightsPullSecretName != nil {
+		envs["INSIGHTS_PULL_SECRET_NAME"] = *config.EnvInsightsPullSecretName
+	}
+	if config.EnvInsightsSSOSecret != nil {
+		envs["INSIGHTS_SSO_SECRET"] = *config.EnvInsightsSSOSecret
+	}
+	if config.EnvInsightsSSOTokenURL != nil {
+		envs["INSIGHTS_SSO_TOKEN_URL"] = *config.EnvInsightsSSOTokenURL
+	}
+	if config.EnvInsightsTrustedCAConfigMap != nil {
+		envs["INSIGHTS_TRUSTED_CA_CONFIGMAP"] = *config.EnvInsightsTrustedCAConfigMap
+	}
+	if config.EnvInsightsProxyURL != nil {
+		envs["INSIGHTS_PROXY_URL"] = *config.EnvInsightsProxyURL
+	}
+	if config.EnvInsightsProxyCredentials != nil {
+		envs["INSIGHTS_PROXY_CREDENTIALS_SECRET"] = *config.EnvInsightsProxyCredentials
+	}
+	if config.EnvInsightsNoProxy != nil {
+		envs["INSIGHTS_NO_PROXY"] = *config.EnvInsightsNoProxy
+	}
 	return &testOSUtils{envs: envs}
 }
 
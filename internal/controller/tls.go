@@ -0,0 +1,387 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// Annotation requesting a serving certificate from the OpenShift service CA operator
+	servingCertSecretAnnotation = "service.beta.openshift.io/serving-cert-secret-name"
+	// Annotation requesting the OpenShift service CA bundle be injected into a config map
+	injectCABundleAnnotation = "service.beta.openshift.io/inject-cabundle"
+
+	caValidity      = 2 * 365 * 24 * time.Hour
+	servingValidity = 90 * 24 * time.Hour
+	// Certificates are reissued once less than this fraction of their lifetime remains
+	renewFraction = 3
+	rsaKeySize    = 2048
+)
+
+var serviceCAKind = schema.GroupVersionKind{Group: "operator.openshift.io", Version: "v1", Kind: "ServiceCA"}
+
+// useServingCerts returns whether the OpenShift service CA operator
+// is available to issue certificates for the proxy Service
+func (r *InsightsReconciler) useServingCerts() (bool, error) {
+	_, err := r.Client.RESTMapper().RESTMapping(serviceCAKind.GroupKind(), serviceCAKind.Version)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// reconcileProxyTLS ensures a serving certificate exists for the proxy Service,
+// and that clients can obtain the CA bundle used to verify it. Returns how long
+// until the certificates managed by the operator should be renewed, or zero if
+// they are managed by OpenShift.
+func (r *InsightsReconciler) reconcileProxyTLS(ctx context.Context) (time.Duration, error) {
+	owner := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.ConfigMap,
+		Namespace: r.Namespace}, owner)
+	if err != nil {
+		return 0, err
+	}
+
+	servingCerts, err := r.useServingCerts()
+	if err != nil {
+		return 0, err
+	}
+	if servingCerts {
+		// The service CA operator creates the serving certificate secret
+		// when the Service is annotated, and injects its CA into the bundle
+		return 0, r.createOrUpdateCABundle(ctx, owner, nil)
+	}
+
+	ca, caKey, err := r.reconcileCA(ctx, owner)
+	if err != nil {
+		return 0, err
+	}
+	// Clients keep trusting a replaced CA until it expires, and the bundle is published
+	// before a serving certificate is issued by the new CA, so that clients holding either
+	// bundle may verify the proxy during a rotation
+	previous, err := r.getPreviousCAs(ctx, ca)
+	if err != nil {
+		return 0, err
+	}
+	err = r.createOrUpdateCABundle(ctx, owner, encodeCertificates(append([]*x509.Certificate{ca}, previous...)))
+	if err != nil {
+		return 0, err
+	}
+	cert, err := r.reconcileServingCert(ctx, owner, ca, caKey)
+	if err != nil {
+		return 0, err
+	}
+
+	// Requeue when the next certificate is due for renewal
+	renewAt := renewalTime(cert)
+	if caRenewAt := renewalTime(ca); caRenewAt.Before(renewAt) {
+		renewAt = caRenewAt
+	}
+	return time.Until(renewAt), nil
+}
+
+func (r *InsightsReconciler) reconcileCA(ctx context.Context, owner metav1.Object) (*x509.Certificate, *rsa.PrivateKey, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.names.CASecret,
+			Namespace: r.Namespace,
+		},
+	}
+	var ca *x509.Certificate
+	var caKey *rsa.PrivateKey
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if err := controllerutil.SetControllerReference(owner, secret, r.Scheme); err != nil {
+			return err
+		}
+		var err error
+		ca, caKey, err = parseKeyPair(secret)
+		if err == nil && !needsRenewal(ca) {
+			return nil
+		}
+
+		ca, caKey, err = newCA(r.names.Service)
+		if err != nil {
+			return err
+		}
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = encodeKeyPair(ca, caKey)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Log.Info(fmt.Sprintf("Secret %s", op), "name", secret.Name, "namespace", secret.Namespace)
+	return ca, caKey, nil
+}
+
+func (r *InsightsReconciler) reconcileServingCert(ctx context.Context, owner metav1.Object, ca *x509.Certificate,
+	caKey *rsa.PrivateKey) (*x509.Certificate, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.names.TLSSecret,
+			Namespace: r.Namespace,
+		},
+	}
+	dnsNames := r.getProxyDNSNames()
+	var cert *x509.Certificate
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if err := controllerutil.SetControllerReference(owner, secret, r.Scheme); err != nil {
+			return err
+		}
+		var err error
+		cert, _, err = parseKeyPair(secret)
+		if err == nil && !needsRenewal(cert) && isIssuedFor(cert, ca, dnsNames) {
+			return nil
+		}
+
+		var key *rsa.PrivateKey
+		cert, key, err = newServingCert(ca, caKey, dnsNames)
+		if err != nil {
+			return err
+		}
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = encodeKeyPair(cert, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Log.Info(fmt.Sprintf("Secret %s", op), "name", secret.Name, "namespace", secret.Namespace)
+	return cert, nil
+}
+
+// getPreviousCAs returns the unexpired CAs of the published bundle other than the current CA
+func (r *InsightsReconciler) getPreviousCAs(ctx context.Context, current *x509.Certificate) ([]*x509.Certificate, error) {
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.CABundleConfigMap,
+		Namespace: r.Namespace}, cm)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if cm.Annotations[injectCABundleAnnotation] == "true" {
+		// The bundle holds the OpenShift service CA, not one managed by the operator
+		return nil, nil
+	}
+
+	previous := []*x509.Certificate{}
+	now := time.Now()
+	rest := []byte(cm.Data[common.ProxyCABundleKey])
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || !cert.IsCA || !now.Before(cert.NotAfter) || cert.Equal(current) {
+			continue
+		}
+		previous = append(previous, cert)
+	}
+	return previous, nil
+}
+
+func (r *InsightsReconciler) createOrUpdateCABundle(ctx context.Context, owner metav1.Object, bundle []byte) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.names.CABundleConfigMap,
+			Namespace: r.Namespace,
+		},
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		if err := controllerutil.SetControllerReference(owner, cm, r.Scheme); err != nil {
+			return err
+		}
+		if bundle == nil {
+			// Let OpenShift inject the service CA bundle
+			common.MergeLabelsAndAnnotations(&cm.ObjectMeta, nil, map[string]string{injectCABundleAnnotation: "true"})
+			return nil
+		}
+		delete(cm.Annotations, injectCABundleAnnotation)
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[common.ProxyCABundleKey] = string(bundle)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.Log.Info(fmt.Sprintf("Config Map %s", op), "name", cm.Name, "namespace", cm.Namespace)
+	return nil
+}
+
+// getProxyTLSCertificate returns the PEM-encoded serving certificate for the proxy,
+// or nil if it has not been issued yet
+func (r *InsightsReconciler) getProxyTLSCertificate(ctx context.Context) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.TLSSecret,
+		Namespace: r.Namespace}, secret)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return secret.Data[corev1.TLSCertKey], nil
+}
+
+func (r *InsightsReconciler) getProxyDNSNames() []string {
+	return []string{
+		r.names.Service,
+		fmt.Sprintf("%s.%s", r.names.Service, r.Namespace),
+		fmt.Sprintf("%s.%s.svc", r.names.Service, r.Namespace),
+		getProxyServiceFQDN(r.names.Service, r.Namespace, r.clusterDomain),
+	}
+}
+
+func newCA(service string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	now := time.Now()
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: fmt.Sprintf("%s-ca@%d", service, now.Unix()),
+		},
+		NotBefore:             now.Add(-time.Hour), // Tolerate clock skew
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return newCertificate(template, nil, nil)
+}
+
+func newServingCert(ca *x509.Certificate, caKey *rsa.PrivateKey, dnsNames []string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	now := time.Now()
+	notAfter := now.Add(servingValidity)
+	// Never outlive the CA
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: dnsNames[len(dnsNames)-1],
+		},
+		DNSNames:    dnsNames,
+		NotBefore:   now.Add(-time.Hour), // Tolerate clock skew
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return newCertificate(template, ca, caKey)
+}
+
+// newCertificate creates a certificate from the template signed by the parent,
+// or self-signed if the parent is nil
+func newCertificate(template *x509.Certificate, parent *x509.Certificate,
+	parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	bundle := []byte{}
+	for _, cert := range certs {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return bundle
+}
+
+func encodeKeyPair(cert *x509.Certificate, key *rsa.PrivateKey) map[string][]byte {
+	return map[string][]byte{
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}
+}
+
+func parseKeyPair(secret *corev1.Secret) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if certBlock == nil {
+		return nil, nil, errors.New("no certificate found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(secret.Data[corev1.TLSPrivateKeyKey])
+	if keyBlock == nil {
+		return nil, nil, errors.New("no private key found")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func renewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Add(-lifetime / renewFraction)
+}
+
+func needsRenewal(cert *x509.Certificate) bool {
+	return !time.Now().Before(renewalTime(cert))
+}
+
+func isIssuedFor(cert *x509.Certificate, ca *x509.Certificate, dnsNames []string) bool {
+	if cert.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
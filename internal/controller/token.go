@@ -0,0 +1,219 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Location of the OpenShift global pull secret
+const (
+	DefaultPullSecretNamespace = "openshift-config"
+	DefaultPullSecretName      = "pull-secret"
+)
+
+// Auth within the global pull secret containing the Red Hat token
+const pullSecretAuthRegistry = "cloud.openshift.com"
+
+// TokenRefreshInterval is how often a token is read again from a TokenSource
+// that is not backed by a Secret, since the controller cannot watch it for changes
+const TokenRefreshInterval = 5 * time.Minute
+
+// TokenSource provides the token the Insights proxy uses to authenticate
+// with Red Hat Insights on behalf of the cluster. Operators embedding this
+// controller may provide their own implementation.
+type TokenSource interface {
+	// GetToken returns the token, using the reader to obtain any objects
+	// it is stored in. Errors may be reported with a reason from the v1alpha1
+	// API package using NewTokenError, otherwise TokenMissing is reported.
+	GetToken(ctx context.Context, reader client.Reader) (string, error)
+	// String describes where the token is found, for use in status messages
+	String() string
+}
+
+// SecretTokenSource is implemented by a TokenSource that reads its token from a Secret.
+// The Insights proxy is reconciled whenever this Secret changes. Other sources are read
+// again every TokenRefreshInterval.
+type SecretTokenSource interface {
+	TokenSource
+	// GetSecret returns the namespace and name of the Secret containing the token
+	GetSecret() types.NamespacedName
+}
+
+// NewTokenError returns an error from a TokenSource reported with the provided reason,
+// which should be one of the Reason constants in the v1alpha1 API package
+func NewTokenError(reason string, err error) error {
+	return newReconcileError(reason, err)
+}
+
+// PullSecretTokenSource reads the cloud.openshift.com credential
+// from a Secret in the format of the OpenShift global pull secret
+type PullSecretTokenSource struct {
+	secret types.NamespacedName
+}
+
+var _ SecretTokenSource = (*PullSecretTokenSource)(nil)
+
+// NewPullSecretTokenSource creates a PullSecretTokenSource for the pull secret with
+// the provided namespace and name. An empty namespace or name defaults to those of
+// the OpenShift global pull secret, openshift-config/pull-secret.
+func NewPullSecretTokenSource(namespace string, name string) *PullSecretTokenSource {
+	if len(namespace) == 0 {
+		namespace = DefaultPullSecretNamespace
+	}
+	if len(name) == 0 {
+		name = DefaultPullSecretName
+	}
+	return &PullSecretTokenSource{
+		secret: types.NamespacedName{Namespace: namespace, Name: name},
+	}
+}
+
+// GetToken returns the cloud.openshift.com auth from the pull secret
+func (s *PullSecretTokenSource) GetToken(ctx context.Context, reader client.Reader) (string, error) {
+	pullSecret := &corev1.Secret{}
+	err := reader.Get(ctx, s.secret, pullSecret)
+	if err != nil {
+		return "", NewTokenError(v1alpha1.ReasonPullSecretUnavailable, err)
+	}
+
+	// Look for the .dockerconfigjson key within it
+	dockerConfigRaw, pres := pullSecret.Data[corev1.DockerConfigJsonKey]
+	if !pres {
+		return "", NewTokenError(v1alpha1.ReasonTokenMissing,
+			fmt.Errorf("no %s key present in pull secret", corev1.DockerConfigJsonKey))
+	}
+
+	// Unmarshal the .dockerconfigjson into a struct
+	dockerConfig := struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	err = json.Unmarshal(dockerConfigRaw, &dockerConfig)
+	if err != nil {
+		return "", NewTokenError(v1alpha1.ReasonTokenInvalid, err)
+	}
+
+	openshiftAuth, pres := dockerConfig.Auths[pullSecretAuthRegistry]
+	if !pres {
+		return "", NewTokenError(v1alpha1.ReasonTokenMissing,
+			fmt.Errorf("no %q auth within pull secret", pullSecretAuthRegistry))
+	}
+	return validateToken(openshiftAuth.Auth, fmt.Sprintf("invalid %s token", pullSecretAuthRegistry))
+}
+
+// GetSecret returns the location of the pull secret
+func (s *PullSecretTokenSource) GetSecret() types.NamespacedName {
+	return s.secret
+}
+
+func (s *PullSecretTokenSource) String() string {
+	if s.secret.Namespace == DefaultPullSecretNamespace && s.secret.Name == DefaultPullSecretName {
+		return "the global pull secret"
+	}
+	return fmt.Sprintf("the pull secret %s", s.secret)
+}
+
+// SecretKeyTokenSource reads the token from a key of a Secret
+type SecretKeyTokenSource struct {
+	secret types.NamespacedName
+	key    string
+}
+
+var _ SecretTokenSource = (*SecretKeyTokenSource)(nil)
+
+// NewSecretKeyTokenSource creates a SecretKeyTokenSource for the Secret with the
+// provided namespace and name. An empty key defaults to "token".
+func NewSecretKeyTokenSource(namespace string, name string, key string) *SecretKeyTokenSource {
+	if len(key) == 0 {
+		key = common.TokenSecretKey
+	}
+	return &SecretKeyTokenSource{
+		secret: types.NamespacedName{Namespace: namespace, Name: name},
+		key:    key,
+	}
+}
+
+// GetToken returns the value of the Secret's key
+func (s *SecretKeyTokenSource) GetToken(ctx context.Context, reader client.Reader) (string, error) {
+	secret := &corev1.Secret{}
+	err := reader.Get(ctx, s.secret, secret)
+	if err != nil {
+		return "", NewTokenError(v1alpha1.ReasonTokenSecretUnavailable, err)
+	}
+
+	raw, pres := secret.Data[s.key]
+	if !pres {
+		return "", NewTokenError(v1alpha1.ReasonTokenMissing,
+			fmt.Errorf("no %s key present in Secret %s", s.key, s.secret.Name))
+	}
+	return validateToken(string(raw), fmt.Sprintf("invalid token in Secret %s", s.secret.Name))
+}
+
+// GetSecret returns the location of the Secret
+func (s *SecretKeyTokenSource) GetSecret() types.NamespacedName {
+	return s.secret
+}
+
+func (s *SecretKeyTokenSource) String() string {
+	return fmt.Sprintf("Secret %s", s.secret.Name)
+}
+
+// FileTokenSource reads the token from a file, such as a Secret
+// or projected volume mounted into the operator's pod
+type FileTokenSource struct {
+	path string
+}
+
+var _ TokenSource = (*FileTokenSource)(nil)
+
+// NewFileTokenSource creates a FileTokenSource for the file at the provided path
+func NewFileTokenSource(path string) *FileTokenSource {
+	return &FileTokenSource{path: path}
+}
+
+// GetToken returns the contents of the file
+func (s *FileTokenSource) GetToken(ctx context.Context, reader client.Reader) (string, error) {
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", NewTokenError(v1alpha1.ReasonTokenMissing, err)
+	}
+	return validateToken(string(raw), fmt.Sprintf("invalid token in file %s", s.path))
+}
+
+func (s *FileTokenSource) String() string {
+	return fmt.Sprintf("file %s", s.path)
+}
+
+func validateToken(raw string, message string) (string, error) {
+	token := strings.TrimSpace(raw)
+	if len(token) == 0 || strings.ContainsAny(token, "\r\n") {
+		return "", NewTokenError(v1alpha1.ReasonTokenInvalid, errors.New(message))
+	}
+	return token, nil
+}
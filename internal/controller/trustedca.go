@@ -0,0 +1,180 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// Label requesting the OpenShift cluster network operator inject the
+	// cluster's trusted CA bundle into a config map
+	injectTrustedCABundleLabel = "config.openshift.io/inject-trusted-cabundle"
+	// Where the trusted CA bundle is mounted in the proxy's container, replacing the trust store of
+	// RHEL-based images that /etc/pki/tls/certs/ca-bundle.crt and /etc/pki/tls/cert.pem link to.
+	// APICast's lua_ssl_trusted_certificate and the Go standard library both read it from there.
+	trustedCAMountPath = "/etc/pki/ca-trust/extracted/pem"
+	trustedCAFileName  = "tls-ca-bundle.pem"
+)
+
+// Locations of the operator's system CA bundle, as searched by crypto/x509
+var systemCABundleFiles = []string{
+	"/etc/pki/tls/certs/ca-bundle.crt",   // Fedora, RHEL
+	"/etc/ssl/certs/ca-certificates.crt", // Debian, Ubuntu, Alpine
+	"/etc/ssl/ca-bundle.pem",             // OpenSUSE
+}
+
+// reconcileTrustedCABundle ensures the config map holding the CAs the proxy trusts
+// for outgoing connections exists, and returns the bundle. Returns nil if no bundle
+// is available, in which case the proxy uses its image's default trust store.
+func (r *InsightsReconciler) reconcileTrustedCABundle(ctx context.Context, config *proxyConfig) ([]byte, error) {
+	owner := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: r.names.ConfigMap,
+		Namespace: r.Namespace}, owner)
+	if err != nil {
+		return nil, newReconcileError(v1alpha1.ReasonConfigMapFailed, err)
+	}
+
+	// A user-provided bundle takes precedence over the one injected by OpenShift
+	var userBundle *string
+	if len(config.trustedCAConfigMap) > 0 {
+		userCM := &corev1.ConfigMap{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: config.trustedCAConfigMap,
+			Namespace: r.Namespace}, userCM)
+		if err != nil {
+			return nil, newReconcileError(v1alpha1.ReasonTrustedCAUnavailable, err)
+		}
+		bundle, pres := userCM.Data[common.TrustedCABundleKey]
+		if !pres {
+			return nil, newReconcileError(v1alpha1.ReasonTrustedCAUnavailable,
+				fmt.Errorf("no %s key present in Config Map %s", common.TrustedCABundleKey, config.trustedCAConfigMap))
+		}
+		// The bundle replaces the proxy's trust store, so it must also contain the public CAs
+		bundle = r.withSystemCABundle(bundle)
+		userBundle = &bundle
+	} else {
+		openshift, err := IsOpenShift(r.Client.RESTMapper())
+		if err != nil {
+			return nil, newReconcileError(v1alpha1.ReasonTrustedCAUnavailable, err)
+		}
+		if !openshift {
+			return nil, nil
+		}
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.names.TrustedCABundleConfigMap,
+			Namespace: r.Namespace,
+		},
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		if err := controllerutil.SetControllerReference(owner, cm, r.Scheme); err != nil {
+			return err
+		}
+		if userBundle == nil {
+			// Let OpenShift inject the cluster's trusted CA bundle
+			common.MergeLabelsAndAnnotations(&cm.ObjectMeta, map[string]string{injectTrustedCABundleLabel: "true"}, nil)
+			return nil
+		}
+		delete(cm.Labels, injectTrustedCABundleLabel)
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[common.TrustedCABundleKey] = *userBundle
+		return nil
+	})
+	if err != nil {
+		return nil, newReconcileError(v1alpha1.ReasonTrustedCAUnavailable, err)
+	}
+	r.Log.Info(fmt.Sprintf("Config Map %s", op), "name", cm.Name, "namespace", cm.Namespace)
+
+	// The bundle may not have been injected yet
+	bundle := cm.Data[common.TrustedCABundleKey]
+	if len(bundle) == 0 {
+		return nil, nil
+	}
+	return []byte(bundle), nil
+}
+
+// withSystemCABundle appends the operator's system CA bundle to a user-provided bundle. The OpenShift
+// injected bundle already contains the system CAs.
+func (r *InsightsReconciler) withSystemCABundle(bundle string) string {
+	system := ReadSystemCABundle()
+	if system == nil {
+		r.Log.Info("no system CA bundle found, the proxy will only trust the user-provided CA bundle")
+		return bundle
+	}
+	return strings.TrimRight(bundle, "\n") + "\n" + string(system)
+}
+
+// ReadSystemCABundle returns the operator's system CA bundle, or nil if none is found
+func ReadSystemCABundle() []byte {
+	for _, file := range systemCABundleFiles {
+		system, err := os.ReadFile(file)
+		if err == nil {
+			return system
+		}
+	}
+	return nil
+}
+
+// setTrustedCABundle mounts the trusted CA bundle in the named config map into the proxy's container
+// in place of its system trust store, which both proxy implementations verify outgoing connections with
+func setTrustedCABundle(podSpec *corev1.PodSpec, container *corev1.Container, configMap string) {
+	readOnlyMode := int32(0444)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      "trusted-ca",
+		MountPath: trustedCAMountPath,
+		ReadOnly:  true,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "trusted-ca",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: configMap,
+				},
+				Items: []corev1.KeyToPath{
+					{
+						Key:  common.TrustedCABundleKey,
+						Path: trustedCAFileName,
+					},
+				},
+				DefaultMode: &readOnlyMode,
+			},
+		},
+	})
+}
+
+func (r *InsightsReconciler) isTrustedCABundle(ctx context.Context, cm client.Object) []reconcile.Request {
+	if cm.GetNamespace() != r.Namespace || (cm.GetName() != r.names.TrustedCABundleConfigMap &&
+		cm.GetName() != r.trustedCAConfigMap) {
+		return nil
+	}
+	return r.proxyDeploymentRequest()
+}
@@ -0,0 +1,170 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"golang.org/x/net/http/httpproxy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getUpstreamProxy returns the URL of the proxy used to reach the Insights backend, or nil
+// if it is reached directly. An explicitly configured proxy takes precedence over the
+// OpenShift cluster-wide Proxy. The URL includes any configured credentials, and must
+// not be logged or reported in status messages.
+func (r *InsightsReconciler) getUpstreamProxy(ctx context.Context, config *proxyConfig) (*url.URL, error) {
+	if config.upstreamProxy != nil {
+		upstream, err := proxyForBackend(&httpproxy.Config{
+			HTTPProxy:  config.upstreamProxy.String(),
+			HTTPSProxy: config.upstreamProxy.String(),
+			NoProxy:    config.noProxy,
+		}, config.backendDomain)
+		if err != nil || upstream == nil {
+			return nil, err
+		}
+		if len(config.proxyCredentialsSecret) > 0 {
+			upstream.User, err = r.getProxyCredentials(ctx, config.proxyCredentialsSecret)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return upstream, nil
+	}
+
+	proxy, err := r.getClusterProxy(ctx)
+	if err != nil {
+		return nil, newReconcileError(v1alpha1.ReasonClusterProxyUnavailable, err)
+	}
+	if proxy == nil {
+		return nil, nil
+	}
+	// The status contains the effective configuration, including
+	// the cluster's own networks in the no-proxy list
+	status := proxy.Status.DeepCopy()
+	status.NoProxy = joinNoProxy(status.NoProxy, config.noProxy)
+	upstream, err := selectUpstreamProxy(status, config.backendDomain)
+	if err != nil {
+		return nil, newReconcileError(v1alpha1.ReasonClusterProxyUnavailable, err)
+	}
+	return upstream, nil
+}
+
+// getProxyCredentials returns the username and password for the
+// upstream proxy from the Secret with the provided name
+func (r *InsightsReconciler) getProxyCredentials(ctx context.Context, name string) (*url.Userinfo, error) {
+	secret := &corev1.Secret{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: r.Namespace}, secret)
+	if err != nil {
+		return nil, newReconcileError(v1alpha1.ReasonProxyCredentialsUnavailable, err)
+	}
+	username := strings.TrimSpace(string(secret.Data[corev1.BasicAuthUsernameKey]))
+	password := strings.TrimSpace(string(secret.Data[corev1.BasicAuthPasswordKey]))
+	if len(username) == 0 || len(password) == 0 {
+		return nil, newReconcileError(v1alpha1.ReasonProxyCredentialsUnavailable,
+			fmt.Errorf("Secret %s must contain both %s and %s keys", name,
+				corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey))
+	}
+	return url.UserPassword(username, password), nil
+}
+
+// parseUpstreamProxy validates the URL of an upstream proxy. For compatibility with
+// INSIGHTS_PROXY_DOMAIN, a value without a scheme is treated as an HTTP proxy.
+// Errors never include the value, in case it contains credentials.
+func parseUpstreamProxy(value string) (*url.URL, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	upstream, err := url.Parse(value)
+	if err != nil {
+		// The parse error quotes the URL
+		return nil, errors.New("upstream proxy is not a valid URL")
+	}
+	if upstream.Scheme != "http" && upstream.Scheme != "https" {
+		return nil, fmt.Errorf("upstream proxy must use the http or https scheme, not %q", upstream.Scheme)
+	}
+	if upstream.User != nil {
+		return nil, errors.New("upstream proxy must not contain credentials, provide them in a Secret instead")
+	}
+	if len(upstream.Hostname()) == 0 {
+		return nil, errors.New("upstream proxy must include a host")
+	}
+	if (len(upstream.Path) > 0 && upstream.Path != "/") || len(upstream.RawQuery) > 0 || len(upstream.Fragment) > 0 {
+		return nil, errors.New("upstream proxy must not include a path, query or fragment")
+	}
+	upstream.Path = ""
+	upstream.RawPath = ""
+	return upstream, nil
+}
+
+// parseNoProxy validates a comma-separated list of destinations excluded
+// from the upstream proxy, and returns it without whitespace or empty entries
+func parseNoProxy(value string) (string, error) {
+	entries := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		if strings.ContainsAny(entry, " \t\r\n") || strings.Contains(entry, "://") {
+			return "", fmt.Errorf("invalid no-proxy entry %q, expected a domain, IP address or CIDR range", entry)
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, ","), nil
+}
+
+func joinNoProxy(lists ...string) string {
+	entries := []string{}
+	for _, list := range lists {
+		if len(list) > 0 {
+			entries = append(entries, list)
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// proxyForBackend returns the proxy for HTTPS requests to the backend domain,
+// or nil if the domain is excluded by the no-proxy list
+func proxyForBackend(config *httpproxy.Config, backendDomain string) (*url.URL, error) {
+	upstream, err := config.ProxyFunc()(&url.URL{Scheme: "https", Host: backendDomain + ":443"})
+	if err != nil || upstream == nil {
+		return nil, err
+	}
+	// Only the location of the proxy is used
+	upstream.Path = ""
+	upstream.RawPath = ""
+	return upstream, nil
+}
+
+func (r *InsightsReconciler) isProxyCredentialsSecret(ctx context.Context, secret client.Object) bool {
+	if secret.GetNamespace() != r.Namespace {
+		return false
+	}
+	if len(r.proxyCredentialsSecret) > 0 && secret.GetName() == r.proxyCredentialsSecret {
+		return true
+	}
+	// The InsightsProxy may name a different Secret
+	_, proxyCredentialsSecret := r.proxySecrets.get()
+	return len(proxyCredentialsSecret) > 0 && secret.GetName() == proxyCredentialsSecret
+}
@@ -0,0 +1,120 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Config is the configuration of the built-in Insights proxy.
+// It is rendered by the Insights controller and mounted into the proxy's pod.
+type Config struct {
+	// URL of the Red Hat Insights server where reports are forwarded (e.g. https://console.redhat.com)
+	BackendURL string `json:"backendURL"`
+	// URL of an HTTP proxy used to reach the backend, if any
+	UpstreamProxyURL string `json:"upstreamProxyURL,omitempty"`
+	// Bearer token sent to the backend in the Authorization header
+	Token string `json:"token"`
+	// Value of the User-Agent header sent to the backend
+	UserAgent string `json:"userAgent"`
+	// HTTP methods clients may use
+	AllowedMethods []string `json:"allowedMethods"`
+	// Paths clients may request. Paths ending in "/" match any path beneath them.
+	AllowedPaths []string `json:"allowedPaths"`
+	// Operators sharing the proxy, whose clients' requests are reported with their own User-Agent
+	Members []Member `json:"members,omitempty"`
+}
+
+// Member is an operator sharing the proxy. Its clients send requests beneath its path prefix,
+// which is removed before the request is forwarded with the member's User-Agent.
+type Member struct {
+	// Path prefix of the member's requests, such as /members/my-namespace/my-operator
+	PathPrefix string `json:"pathPrefix"`
+	// Value of the User-Agent header sent to the backend for the member's requests
+	UserAgent string `json:"userAgent"`
+}
+
+// Defaults for the requests accepted by the proxy, matching
+// those made by the Insights Java client and agent
+var (
+	DefaultAllowedMethods = []string{http.MethodPost}
+	DefaultAllowedPaths   = []string{"/api/ingress/v1/upload"}
+)
+
+// LoadConfig reads and validates a proxy configuration from a JSON file
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	err = json.Unmarshal(raw, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy configuration: %w", err)
+	}
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate returns an error if the configuration is incomplete or malformed
+func (c *Config) Validate() error {
+	backend, err := url.Parse(c.BackendURL)
+	if err != nil {
+		return fmt.Errorf("invalid backend URL: %w", err)
+	}
+	if backend.Scheme != "https" || len(backend.Host) == 0 {
+		return fmt.Errorf("backend URL must be an absolute https URL: %q", c.BackendURL)
+	}
+	if len(c.UpstreamProxyURL) > 0 {
+		// The upstream proxy URL may contain credentials, never include it in errors
+		upstream, err := url.Parse(c.UpstreamProxyURL)
+		if err != nil {
+			return errors.New("invalid upstream proxy URL")
+		}
+		if (upstream.Scheme != "http" && upstream.Scheme != "https") || len(upstream.Host) == 0 {
+			return fmt.Errorf("upstream proxy URL must be an absolute http or https URL: %q", upstream.Redacted())
+		}
+	}
+	if len(c.Token) == 0 {
+		return errors.New("no token provided")
+	}
+	if strings.ContainsAny(c.Token, "\r\n") || strings.ContainsAny(c.UserAgent, "\r\n") {
+		return errors.New("headers must not contain line breaks")
+	}
+	if len(c.AllowedMethods) == 0 || len(c.AllowedPaths) == 0 {
+		return errors.New("at least one allowed method and path must be provided")
+	}
+	for _, member := range c.Members {
+		if !strings.HasPrefix(member.PathPrefix, "/") || strings.HasSuffix(member.PathPrefix, "/") ||
+			path.Clean(member.PathPrefix) != member.PathPrefix {
+			return fmt.Errorf("member path prefix must be a clean absolute path without a trailing slash: %q",
+				member.PathPrefix)
+		}
+		if strings.ContainsAny(member.UserAgent, "\r\n") {
+			return errors.New("headers must not contain line breaks")
+		}
+	}
+	return nil
+}
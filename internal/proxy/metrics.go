@@ -0,0 +1,60 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "runtimes_inventory"
+	metricsSubsystem = "proxy"
+)
+
+type proxyMetrics struct {
+	requests *prometheus.CounterVec
+	rejected *prometheus.CounterVec
+	duration prometheus.Histogram
+}
+
+func newProxyMetrics(registerer prometheus.Registerer) (*proxyMetrics, error) {
+	m := &proxyMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "requests_total",
+			Help:      "Total number of requests forwarded to Red Hat Insights, by method and response code",
+		}, []string{"method", "code"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "rejected_requests_total",
+			Help:      "Total number of requests rejected by the proxy, by reason",
+		}, []string{"reason"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to forward requests to Red Hat Insights",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+	for _, collector := range []prometheus.Collector{m.requests, m.rejected, m.duration} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
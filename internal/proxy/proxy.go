@@ -0,0 +1,205 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ErrorHeader classifies the failure to forward a request, on the responses to such requests
+	ErrorHeader = "X-Insights-Proxy-Error"
+	// ErrorTLS indicates the certificate of the backend, or of the upstream proxy, could not be
+	// verified. This is typically the case behind a proxy inspecting TLS traffic with its own CA.
+	ErrorTLS = "tls"
+)
+
+// Proxy is an HTTP handler that forwards reports from workloads to Red Hat Insights,
+// authenticating them on the workloads' behalf
+type Proxy struct {
+	config  *Config
+	backend *url.URL
+	reverse *httputil.ReverseProxy
+	metrics *proxyMetrics
+	log     logr.Logger
+}
+
+// NewProxy creates a Proxy from a validated configuration,
+// registering its metrics with the provided registerer
+func NewProxy(config *Config, registerer prometheus.Registerer, log logr.Logger) (*Proxy, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	backend, err := url.Parse(config.BackendURL)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := newProxyMetrics(registerer)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Ignore any proxy settings from the environment, only use the configured proxy
+	transport.Proxy = nil
+	if len(config.UpstreamProxyURL) > 0 {
+		upstream, err := url.Parse(config.UpstreamProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(upstream)
+	}
+
+	p := &Proxy{
+		config:  config,
+		backend: backend,
+		metrics: metrics,
+		log:     log,
+	}
+	p.reverse = &httputil.ReverseProxy{
+		Director:     p.direct,
+		Transport:    transport,
+		ErrorHandler: p.handleError,
+	}
+	return p, nil
+}
+
+// ServeHTTP forwards allowed requests to the backend
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Requests of a member sharing the proxy are reported with its own User-Agent
+	userAgent, reqPath := p.getMember(r.URL.Path)
+	r.URL.Path = reqPath
+	r.URL.RawPath = ""
+	r.Header.Set("User-Agent", userAgent)
+
+	if !p.isMethodAllowed(r.Method) {
+		p.reject(w, r, http.StatusMethodNotAllowed, "method")
+		return
+	}
+	if !p.isPathAllowed(r.URL.Path) {
+		p.reject(w, r, http.StatusForbidden, "path")
+		return
+	}
+
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	p.reverse.ServeHTTP(rec, r)
+	p.metrics.requests.WithLabelValues(r.Method, strconv.Itoa(rec.status)).Inc()
+	p.metrics.duration.Observe(time.Since(start).Seconds())
+}
+
+func (p *Proxy) direct(r *http.Request) {
+	r.URL.Scheme = p.backend.Scheme
+	r.URL.Host = p.backend.Host
+	r.URL.Path = singleJoiningSlash(p.backend.Path, path.Clean("/"+r.URL.Path))
+	r.URL.RawPath = ""
+	r.Host = p.backend.Host
+
+	// Never forward client credentials, authenticate as the cluster instead
+	r.Header.Del("Cookie")
+	r.Header.Set("Authorization", "Bearer "+p.config.Token)
+}
+
+// getMember returns the User-Agent for a request path, and the path without the prefix
+// of the member sharing the proxy it belongs to, if any
+func (p *Proxy) getMember(reqPath string) (string, string) {
+	cleaned := path.Clean("/" + reqPath)
+	for _, member := range p.config.Members {
+		if strings.HasPrefix(cleaned, member.PathPrefix+"/") {
+			return member.UserAgent, strings.TrimPrefix(cleaned, member.PathPrefix)
+		}
+	}
+	return p.config.UserAgent, reqPath
+}
+
+func (p *Proxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	p.log.Error(err, "failed to forward request", "method", r.Method, "path", r.URL.Path)
+	if IsTLSError(err) {
+		w.Header().Set(ErrorHeader, ErrorTLS)
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// IsTLSError returns whether the error is caused by a certificate that could not be verified,
+// or by a peer not speaking TLS
+func IsTLSError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	return errors.As(err, &certErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &recordErr)
+}
+
+func (p *Proxy) reject(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	p.log.V(1).Info("rejected request", "method", r.Method, "path", r.URL.Path, "reason", reason)
+	p.metrics.rejected.WithLabelValues(reason).Inc()
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (p *Proxy) isMethodAllowed(method string) bool {
+	for _, allowed := range p.config.AllowedMethods {
+		if strings.EqualFold(method, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) isPathAllowed(reqPath string) bool {
+	// Prevent escaping an allowed prefix with dot segments
+	cleaned := path.Clean("/" + reqPath)
+	for _, allowed := range p.config.AllowedPaths {
+		if cleaned == allowed ||
+			(strings.HasSuffix(allowed, "/") && strings.HasPrefix(cleaned, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+func singleJoiningSlash(a, b string) string {
+	return strings.TrimSuffix(a, "/") + "/" + strings.TrimPrefix(b, "/")
+}
+
+// statusRecorder captures the status code written by the reverse proxy
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
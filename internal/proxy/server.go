@@ -0,0 +1,131 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Paths served by the management server, matching those of APICast
+// so that either proxy may be probed the same way
+const (
+	LivenessPath  = "/status/live"
+	ReadinessPath = "/status/ready"
+	MetricsPath   = "/metrics"
+)
+
+// ServerOptions configures the servers started by Run
+type ServerOptions struct {
+	// Address the HTTPS proxy listens on
+	ProxyAddress string
+	// Address serving liveness and readiness probes
+	ManagementAddress string
+	// Address serving Prometheus metrics
+	MetricsAddress string
+	// Serving certificate and key for the proxy
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// Run serves the proxy, its health probes and its metrics until the context is cancelled
+func Run(ctx context.Context, config *Config, opts *ServerOptions, log logr.Logger) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	proxy, err := NewProxy(config, registry, log)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+
+	servers := []*http.Server{
+		newServer(opts.ProxyAddress, proxy),
+		newServer(opts.ManagementAddress, NewManagementHandler()),
+		newServer(opts.MetricsAddress, newMetricsHandler(registry)),
+	}
+	servers[0].TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	errs := make(chan error, len(servers))
+	for i, server := range servers {
+		go func(server *http.Server, useTLS bool) {
+			log.Info("starting server", "address", server.Addr, "tls", useTLS)
+			var err error
+			if useTLS {
+				// Certificates are provided by TLSConfig
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(server, i == 0)
+	}
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	return err
+}
+
+// NewManagementHandler returns a handler serving the proxy's health probes.
+// The proxy's configuration is loaded before any server starts,
+// so it is ready as soon as it is live.
+func NewManagementHandler() http.Handler {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	mux.HandleFunc(LivenessPath, ok)
+	mux.HandleFunc(ReadinessPath, ok)
+	return mux
+}
+
+func newMetricsHandler(registry *prometheus.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+func newServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Path the Java injection webhook is served at
+const JavaInjectorPath = "/mutate-insights-java"
+
+const (
+	// Environment variables read by the Insights Java client
+	EnvUploadBaseURL      = "RHT_INSIGHTS_JAVA_UPLOAD_BASE_URL"
+	EnvIdentificationName = "RHT_INSIGHTS_JAVA_IDENTIFICATION_NAME"
+	// Environment variable read by the JVM for additional options
+	EnvJavaToolOptions = "JAVA_TOOL_OPTIONS"
+	// Default location of the agent JAR within the agent image
+	DefaultAgentPath = "/opt/insights/runtimes-agent.jar"
+
+	agentVolumeName        = "insights-java-agent"
+	agentInitContainerName = "insights-java-agent"
+	agentMountPath         = "/var/run/insights-java-agent"
+	agentFileName          = "runtimes-agent.jar"
+)
+
+//+kubebuilder:webhook:path=/mutate-insights-java,mutating=true,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups="",resources=pods,verbs=create,versions=v1,name=java.runtimes-inventory.redhat.com,admissionReviewVersions=v1
+
+// JavaInjector is a mutating admission webhook that configures the Insights Java client
+// and agent of new pods to report through the Insights proxy. Only pods labelled with
+// runtimes-inventory.redhat.com/inject-java=true, or in namespaces with this label, are modified.
+// The JVMs of these pods are configured to trust the proxy's CA using a trust store
+// the webhook maintains in their namespace.
+type JavaInjector struct {
+	*JavaInjectorConfig
+	decoder *admission.Decoder
+	names   *common.ResourceNames
+}
+
+// JavaInjectorConfig contains configuration to create a JavaInjector
+type JavaInjectorConfig struct {
+	// Client for objects in the operator's namespace
+	Client client.Reader
+	// Reader for namespaces and config maps across the cluster, the Client is used if unset
+	APIReader client.Reader
+	// Writer for the trust store config maps in the namespaces of configured pods
+	Writer client.Writer
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+	// Namespace of the operator and its Insights proxy
+	Namespace string
+	// Name of the instance of the Insights integration whose proxy
+	// is used, empty for the default instance
+	Instance string
+	// URL of the Insights proxy, defaults to that of its Service
+	// in Namespace using the default cluster domain and port
+	ProxyURL *url.URL
+	// Image containing the Insights Java agent. If unset, only
+	// the environment variables for the client are injected.
+	AgentImage string
+	// Location of the agent JAR within the agent image,
+	// defaults to DefaultAgentPath
+	AgentPath string
+}
+
+var _ admission.Handler = (*JavaInjector)(nil)
+
+// NewJavaInjector creates a JavaInjector using the provided configuration
+func NewJavaInjector(config *JavaInjectorConfig) *JavaInjector {
+	if len(config.AgentPath) == 0 {
+		config.AgentPath = DefaultAgentPath
+	}
+	names := common.NewResourceNames(config.Instance)
+	if config.ProxyURL == nil {
+		config.ProxyURL = controller.GetProxyURL(names.Service, config.Namespace, common.DefaultClusterDomain,
+			controller.DefaultProxyPorts.HTTPS)
+	}
+	return &JavaInjector{
+		JavaInjectorConfig: config,
+		decoder:            admission.NewDecoder(config.Scheme),
+		names:              names,
+	}
+}
+
+// Handle injects the Insights configuration into pods that opted in
+func (i *JavaInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	err := i.decoder.Decode(req, pod)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	// The namespace is not yet set on pods created by controllers
+	namespace := req.Namespace
+	if len(namespace) == 0 {
+		namespace = pod.Namespace
+	}
+
+	selected, err := i.isSelected(ctx, pod, namespace)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !selected {
+		return admission.Allowed("pod not selected for Insights")
+	}
+	enabled, err := i.isProxyEnabled(ctx)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !enabled {
+		return admission.Allowed("the Insights proxy is disabled")
+	}
+
+	dryRun := req.DryRun != nil && *req.DryRun
+	trustStore, err := i.reconcileTrustStore(ctx, namespace, dryRun)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !trustStore {
+		i.Log.Info("the CA bundle of the Insights proxy is not yet available, pods will not trust the proxy",
+			"namespace", namespace)
+	}
+
+	if !i.inject(pod, trustStore) {
+		return admission.Allowed("pod already configured for Insights")
+	}
+	marshaled, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	i.Log.V(1).Info("configured pod for Insights", "namespace", namespace,
+		"name", pod.Name, "generateName", pod.GenerateName)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// isSelected returns whether the pod, or the namespace it is created in, opted in.
+// A pod may opt out by setting the label to false.
+func (i *JavaInjector) isSelected(ctx context.Context, pod *corev1.Pod, namespace string) (bool, error) {
+	if namespace == i.Namespace {
+		return false, nil
+	}
+	if value, pres := pod.Labels[common.InjectJavaLabel]; pres {
+		return value == "true", nil
+	}
+	ns := &corev1.Namespace{}
+	err := i.getAPIReader().Get(ctx, types.NamespacedName{Name: namespace}, ns)
+	if err != nil {
+		return false, err
+	}
+	return ns.Labels[common.InjectJavaLabel] == "true", nil
+}
+
+// isProxyEnabled returns whether the Insights proxy is deployed, which
+// is indicated by the presence of its parent config map
+func (i *JavaInjector) isProxyEnabled(ctx context.Context) (bool, error) {
+	cm := &corev1.ConfigMap{}
+	err := i.Client.Get(ctx, types.NamespacedName{Name: i.names.ConfigMap,
+		Namespace: i.Namespace}, cm)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// inject adds the Insights configuration to the pod, and returns false
+// if the pod was already configured. The trust store is mounted if present.
+func (i *JavaInjector) inject(pod *corev1.Pod, trustStore bool) bool {
+	if pod.Annotations[common.JavaInjectedAnnotation] == "true" {
+		return false
+	}
+	common.MergeLabelsAndAnnotations(&pod.ObjectMeta, nil, map[string]string{common.JavaInjectedAnnotation: "true"})
+
+	baseURL := i.ProxyURL.String()
+	name := getIdentificationName(pod)
+	injectAgent := len(i.AgentImage) > 0 && pod.Annotations[common.InjectJavaAgentAnnotation] != "false"
+	for idx := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[idx]
+		setEnvIfUnset(container, EnvUploadBaseURL, baseURL)
+		if len(name) > 0 {
+			setEnvIfUnset(container, EnvIdentificationName, name)
+		}
+		// A trust store configured by the workload is left in place, and must contain the proxy's CA
+		if trustStore && !hasTrustStoreOption(container) &&
+			appendJavaToolOption(container, getTrustStoreOptions()) {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      trustStoreVolumeName,
+				MountPath: trustStoreMountPath,
+				ReadOnly:  true,
+			})
+		}
+		if injectAgent && appendJavaToolOption(container, getAgentOption(baseURL, name)) {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      agentVolumeName,
+				MountPath: agentMountPath,
+				ReadOnly:  true,
+			})
+		}
+	}
+	if trustStore {
+		i.addTrustStoreVolume(pod)
+	}
+	if injectAgent {
+		i.addAgentInitContainer(pod)
+	}
+	return true
+}
+
+// addAgentInitContainer copies the agent JAR from its image into a volume shared with the pod's containers
+func (i *JavaInjector) addAgentInitContainer(pod *corev1.Pod) {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: agentVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		Name:    agentInitContainerName,
+		Image:   i.AgentImage,
+		Command: []string{"cp", i.AgentPath, path.Join(agentMountPath, agentFileName)},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      agentVolumeName,
+				MountPath: agentMountPath,
+			},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("32Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &[]bool{false}[0],
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+			},
+		},
+	})
+}
+
+func (i *JavaInjector) getAPIReader() client.Reader {
+	if i.APIReader != nil {
+		return i.APIReader
+	}
+	return i.Client
+}
+
+// getIdentificationName returns the name the workload is reported under,
+// using the recommended Kubernetes labels where available
+func getIdentificationName(pod *corev1.Pod) string {
+	for _, label := range []string{"app.kubernetes.io/name", "app"} {
+		if name := pod.Labels[label]; len(name) > 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+func getAgentOption(baseURL string, name string) string {
+	args := []string{"base_url=" + baseURL}
+	if len(name) > 0 {
+		args = append(args, "name="+name)
+	}
+	return fmt.Sprintf("-javaagent:%s=%s", path.Join(agentMountPath, agentFileName), strings.Join(args, ";"))
+}
+
+func setEnvIfUnset(container *corev1.Container, name string, value string) {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+}
+
+// appendJavaToolOption adds the option to any JAVA_TOOL_OPTIONS already set on the container.
+// Returns false if the options are obtained from another source, and cannot be combined.
+func appendJavaToolOption(container *corev1.Container, option string) bool {
+	for idx := range container.Env {
+		env := &container.Env[idx]
+		if env.Name != EnvJavaToolOptions {
+			continue
+		}
+		if env.ValueFrom != nil {
+			return false
+		}
+		env.Value = strings.TrimSpace(env.Value + " " + option)
+		return true
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: EnvJavaToolOptions, Value: option})
+	return true
+}
@@ -0,0 +1,396 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const proxyURL = "https://insights-proxy.operator.svc.cluster.local:8443"
+
+var _ = Describe("JavaInjector", func() {
+	var objs []client.Object
+	var config *JavaInjectorConfig
+	var injector *JavaInjector
+	var pod *corev1.Pod
+	var trustStore bool
+
+	BeforeEach(func() {
+		objs = []client.Object{
+			newNamespace("operator", false),
+			newNamespace("selected", true),
+			newNamespace("other", false),
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "insights-proxy",
+					Namespace: "operator",
+				},
+			},
+		}
+		trustStore = false
+		config = &JavaInjectorConfig{
+			Scheme:    scheme.Scheme,
+			Log:       zap.New(zap.WriteTo(GinkgoWriter)),
+			Namespace: "operator",
+		}
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "app-",
+				Labels: map[string]string{
+					"app.kubernetes.io/name": "inventory",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:  "app",
+						Image: "example.com/app:latest",
+					},
+				},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()
+		config.Client = fakeClient
+		config.Writer = fakeClient
+		injector = NewJavaInjector(config)
+	})
+
+	handle := func(namespace string) (admission.Response, *corev1.Pod) {
+		raw, err := json.Marshal(pod)
+		Expect(err).ToNot(HaveOccurred())
+		resp := injector.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Namespace: namespace,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(resp.Allowed).To(BeTrue())
+		// The patches are computed from the pod modified by inject
+		mutated := pod.DeepCopy()
+		if len(resp.Patches) > 0 {
+			Expect(injector.inject(mutated, trustStore)).To(BeTrue())
+		}
+		return resp, mutated
+	}
+
+	Context("for a pod in a selected namespace", func() {
+		It("should configure the Insights Java client", func() {
+			resp, mutated := handle("selected")
+			Expect(resp.Patches).ToNot(BeEmpty())
+			Expect(mutated.Spec.Containers[0].Env).To(ConsistOf(
+				corev1.EnvVar{Name: EnvUploadBaseURL, Value: proxyURL},
+				corev1.EnvVar{Name: EnvIdentificationName, Value: "inventory"},
+			))
+			Expect(mutated.Annotations).To(HaveKeyWithValue("runtimes-inventory.redhat.com/java-injected", "true"))
+			Expect(mutated.Spec.InitContainers).To(BeEmpty())
+		})
+
+		It("should not override existing configuration", func() {
+			pod.Spec.Containers[0].Env = []corev1.EnvVar{
+				{Name: EnvUploadBaseURL, Value: "https://example.com"},
+			}
+			_, mutated := handle("selected")
+			Expect(mutated.Spec.Containers[0].Env).To(ContainElement(
+				corev1.EnvVar{Name: EnvUploadBaseURL, Value: "https://example.com"}))
+		})
+
+		It("should not modify a pod that was already configured", func() {
+			pod.Annotations = map[string]string{"runtimes-inventory.redhat.com/java-injected": "true"}
+			resp, _ := handle("selected")
+			Expect(resp.Patches).To(BeEmpty())
+		})
+
+		It("should not modify a pod that opted out", func() {
+			pod.Labels["runtimes-inventory.redhat.com/inject-java"] = "false"
+			resp, _ := handle("selected")
+			Expect(resp.Patches).To(BeEmpty())
+		})
+
+		Context("with the Insights proxy disabled", func() {
+			BeforeEach(func() {
+				objs = objs[:len(objs)-1]
+			})
+
+			It("should not modify the pod", func() {
+				resp, _ := handle("selected")
+				Expect(resp.Patches).To(BeEmpty())
+			})
+		})
+
+		Context("with an agent image", func() {
+			BeforeEach(func() {
+				config.AgentImage = "example.com/insights-agent:latest"
+			})
+
+			It("should add the agent", func() {
+				_, mutated := handle("selected")
+				Expect(mutated.Spec.InitContainers).To(HaveLen(1))
+				initContainer := mutated.Spec.InitContainers[0]
+				Expect(initContainer.Image).To(Equal("example.com/insights-agent:latest"))
+				Expect(initContainer.Command).To(Equal([]string{"cp", DefaultAgentPath,
+					"/var/run/insights-java-agent/runtimes-agent.jar"}))
+				Expect(mutated.Spec.Volumes).To(ConsistOf(corev1.Volume{
+					Name:         "insights-java-agent",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}))
+
+				container := mutated.Spec.Containers[0]
+				Expect(container.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+					Name:      "insights-java-agent",
+					MountPath: "/var/run/insights-java-agent",
+					ReadOnly:  true,
+				}))
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{
+					Name:  EnvJavaToolOptions,
+					Value: "-javaagent:/var/run/insights-java-agent/runtimes-agent.jar=base_url=" + proxyURL + ";name=inventory",
+				}))
+			})
+
+			It("should append to existing JVM options", func() {
+				pod.Spec.Containers[0].Env = []corev1.EnvVar{
+					{Name: EnvJavaToolOptions, Value: "-Xmx512m"},
+				}
+				_, mutated := handle("selected")
+				Expect(mutated.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{
+					Name:  EnvJavaToolOptions,
+					Value: "-Xmx512m -javaagent:/var/run/insights-java-agent/runtimes-agent.jar=base_url=" + proxyURL + ";name=inventory",
+				}))
+			})
+
+			It("should not add the agent if the pod opted out", func() {
+				pod.Annotations = map[string]string{"runtimes-inventory.redhat.com/inject-java-agent": "false"}
+				_, mutated := handle("selected")
+				Expect(mutated.Spec.InitContainers).To(BeEmpty())
+				Expect(mutated.Spec.Containers[0].Env).To(HaveLen(2))
+			})
+		})
+		Context("with the CA bundle of the Insights proxy", func() {
+			var caCert *x509.Certificate
+
+			BeforeEach(func() {
+				var bundle string
+				caCert, bundle = newCABundle()
+				objs = append(objs, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "insights-proxy-ca",
+						Namespace: "operator",
+					},
+					Data: map[string]string{"service-ca.crt": bundle},
+				})
+				trustStore = true
+			})
+
+			It("should create a trust store containing the CA", func() {
+				handle("selected")
+				cm := &corev1.ConfigMap{}
+				err := config.Client.Get(context.Background(), types.NamespacedName{
+					Name: "insights-java-truststore", Namespace: "selected"}, cm)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cm.BinaryData).To(HaveKey("truststore.jks"))
+				Expect(cm.Labels).To(Equal(map[string]string{
+					"app.kubernetes.io/name":     "insights-java-truststore",
+					"app.kubernetes.io/instance": "insights-java-truststore",
+				}))
+				certs := parseJavaTrustStore(cm.BinaryData["truststore.jks"], trustStorePassword)
+				Expect(certs).ToNot(BeEmpty())
+				Expect(certs[0]).To(Equal(caCert.Raw))
+			})
+
+			It("should configure the JVM to trust the proxy", func() {
+				_, mutated := handle("selected")
+				readOnlyMode := int32(0444)
+				Expect(mutated.Spec.Volumes).To(ConsistOf(corev1.Volume{
+					Name: "insights-java-truststore",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "insights-java-truststore"},
+							DefaultMode:          &readOnlyMode,
+						},
+					},
+				}))
+				container := mutated.Spec.Containers[0]
+				Expect(container.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+					Name:      "insights-java-truststore",
+					MountPath: "/var/run/insights-java-truststore",
+					ReadOnly:  true,
+				}))
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{
+					Name: EnvJavaToolOptions,
+					Value: "-Djavax.net.ssl.trustStore=/var/run/insights-java-truststore/truststore.jks " +
+						"-Djavax.net.ssl.trustStoreType=JKS",
+				}))
+			})
+
+			It("should not replace a trust store configured by the pod", func() {
+				pod.Spec.Containers[0].Env = []corev1.EnvVar{
+					{Name: EnvJavaToolOptions, Value: "-Djavax.net.ssl.trustStore=/etc/app/truststore.p12"},
+				}
+				_, mutated := handle("selected")
+				container := mutated.Spec.Containers[0]
+				Expect(container.VolumeMounts).To(BeEmpty())
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{
+					Name:  EnvJavaToolOptions,
+					Value: "-Djavax.net.ssl.trustStore=/etc/app/truststore.p12",
+				}))
+			})
+
+			Context("with an outdated trust store", func() {
+				BeforeEach(func() {
+					objs = append(objs, &corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "insights-java-truststore",
+							Namespace: "selected",
+						},
+						BinaryData: map[string][]byte{"truststore.jks": []byte("outdated")},
+					})
+				})
+
+				It("should update the trust store", func() {
+					handle("selected")
+					cm := &corev1.ConfigMap{}
+					err := config.Client.Get(context.Background(), types.NamespacedName{
+						Name: "insights-java-truststore", Namespace: "selected"}, cm)
+					Expect(err).ToNot(HaveOccurred())
+					certs := parseJavaTrustStore(cm.BinaryData["truststore.jks"], trustStorePassword)
+					Expect(certs).ToNot(BeEmpty())
+					Expect(certs[0]).To(Equal(caCert.Raw))
+					Expect(cm.Labels).To(HaveKeyWithValue("app.kubernetes.io/name", "insights-java-truststore"))
+				})
+			})
+		})
+	})
+
+	Context("for a labelled pod", func() {
+		BeforeEach(func() {
+			pod.Labels["runtimes-inventory.redhat.com/inject-java"] = "true"
+		})
+
+		It("should configure the pod in any namespace", func() {
+			resp, _ := handle("other")
+			Expect(resp.Patches).ToNot(BeEmpty())
+		})
+
+		It("should not configure pods in the operator's namespace", func() {
+			resp, _ := handle("operator")
+			Expect(resp.Patches).To(BeEmpty())
+		})
+	})
+
+	It("should not modify other pods", func() {
+		resp, _ := handle("other")
+		Expect(resp.Patches).To(BeEmpty())
+	})
+})
+
+func newNamespace(name string, selected bool) *corev1.Namespace {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	if selected {
+		ns.Labels = map[string]string{"runtimes-inventory.redhat.com/inject-java": "true"}
+	}
+	return ns
+}
+
+func newCABundle() (*x509.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "insights-proxy-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// parseJavaTrustStore verifies the integrity of a JKS trust store, and returns its certificates
+func parseJavaTrustStore(data []byte, password string) [][]byte {
+	Expect(len(data)).To(BeNumerically(">", sha1.Size))
+	contents, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	Expect(getJKSDigest(password, contents)).To(Equal(digest))
+
+	reader := bytes.NewReader(contents)
+	readInt := func(v any) {
+		Expect(binary.Read(reader, binary.BigEndian, v)).To(Succeed())
+	}
+	readUTF := func() string {
+		var length uint16
+		readInt(&length)
+		buf := make([]byte, length)
+		readInt(buf)
+		return string(buf)
+	}
+	var magic, version, count uint32
+	readInt(&magic)
+	readInt(&version)
+	readInt(&count)
+	Expect(magic).To(Equal(uint32(0xfeedfeed)))
+	Expect(version).To(Equal(uint32(2)))
+	certs := [][]byte{}
+	for idx := uint32(0); idx < count; idx++ {
+		var tag, length uint32
+		var date int64
+		readInt(&tag)
+		Expect(tag).To(Equal(uint32(2)))
+		Expect(readUTF()).ToNot(BeEmpty())
+		readInt(&date)
+		Expect(readUTF()).To(Equal("X.509"))
+		readInt(&length)
+		cert := make([]byte, length)
+		readInt(cert)
+		certs = append(certs, cert)
+	}
+	Expect(reader.Len()).To(BeZero())
+	return certs
+}
@@ -0,0 +1,233 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// Password the integrity check of the generated trust store is computed with. JVMs load
+	// trust stores without a password by skipping this check, so it is not passed to them.
+	// The trust store contains no secrets.
+	trustStorePassword = "changeit"
+
+	trustStoreVolumeName = "insights-java-truststore"
+	trustStoreMountPath  = "/var/run/insights-java-truststore"
+
+	// Magic number, version and entry tag of the JKS format
+	jksMagic             = 0xfeedfeed
+	jksVersion           = 2
+	jksTrustedCertTag    = 2
+	jksIntegritySaltText = "Mighty Aphrodite"
+)
+
+// getTrustStoreOptions returns the JVM options replacing the default trust store
+// with the one mounted from the trust store config map
+func getTrustStoreOptions() string {
+	return strings.Join([]string{
+		"-Djavax.net.ssl.trustStore=" + path.Join(trustStoreMountPath, common.JavaTrustStoreKey),
+		"-Djavax.net.ssl.trustStoreType=JKS",
+	}, " ")
+}
+
+// reconcileTrustStore ensures the namespace contains a config map with a Java trust store holding
+// the proxy's CA bundle, along with the operator's system CAs so the workload may continue to
+// verify public endpoints. Returns false if the proxy's CA bundle is not yet available.
+func (i *JavaInjector) reconcileTrustStore(ctx context.Context, namespace string, dryRun bool) (bool, error) {
+	caCM := &corev1.ConfigMap{}
+	err := i.Client.Get(ctx, types.NamespacedName{Name: i.names.CABundleConfigMap,
+		Namespace: i.Namespace}, caCM)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	// On OpenShift, the bundle may not have been injected yet
+	bundle := caCM.Data[common.ProxyCABundleKey]
+	if len(bundle) == 0 {
+		return false, nil
+	}
+	trustStore, err := newJavaTrustStore([]byte(strings.TrimRight(bundle, "\n")+"\n"),
+		controller.ReadSystemCABundle())
+	if err != nil {
+		return false, err
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	// Pods of the same workload are often created concurrently
+	err = retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return kerrors.IsConflict(err) || kerrors.IsAlreadyExists(err)
+	}, func() error {
+		cm := &corev1.ConfigMap{}
+		err := i.getAPIReader().Get(ctx, types.NamespacedName{Name: i.names.JavaTrustStoreConfigMap,
+			Namespace: namespace}, cm)
+		if kerrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      i.names.JavaTrustStoreConfigMap,
+					Namespace: namespace,
+					Labels:    i.getTrustStoreLabels(),
+				},
+				BinaryData: map[string][]byte{common.JavaTrustStoreKey: trustStore},
+			}
+			return i.Writer.Create(ctx, cm)
+		} else if err != nil {
+			return err
+		}
+		labels := i.getTrustStoreLabels()
+		if bytes.Equal(cm.BinaryData[common.JavaTrustStoreKey], trustStore) && hasLabels(cm, labels) {
+			return nil
+		}
+		common.MergeLabelsAndAnnotations(&cm.ObjectMeta, labels, nil)
+		if cm.BinaryData == nil {
+			cm.BinaryData = map[string][]byte{}
+		}
+		cm.BinaryData[common.JavaTrustStoreKey] = trustStore
+		return i.Writer.Update(ctx, cm)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getTrustStoreLabels returns the labels identifying the trust store config maps of this instance.
+// These are left in the namespaces of pods once injection stops, or the operator is removed,
+// so the labels allow them to be found and deleted across the cluster.
+func (i *JavaInjector) getTrustStoreLabels() map[string]string {
+	return map[string]string{
+		common.NameLabel:     common.JavaTrustStoreConfigMapName,
+		common.InstanceLabel: i.names.JavaTrustStoreConfigMap,
+	}
+}
+
+func hasLabels(cm *corev1.ConfigMap, labels map[string]string) bool {
+	for key, value := range labels {
+		if cm.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// addTrustStoreVolume adds the trust store config map as a volume of the pod
+func (i *JavaInjector) addTrustStoreVolume(pod *corev1.Pod) {
+	readOnlyMode := int32(0444)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: trustStoreVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: i.names.JavaTrustStoreConfigMap,
+				},
+				DefaultMode: &readOnlyMode,
+			},
+		},
+	})
+}
+
+// hasTrustStoreOption returns whether the container already configures its own trust store
+func hasTrustStoreOption(container *corev1.Container) bool {
+	for _, env := range container.Env {
+		if env.Name == EnvJavaToolOptions && strings.Contains(env.Value, "-Djavax.net.ssl.trustStore=") {
+			return true
+		}
+	}
+	return false
+}
+
+// newJavaTrustStore encodes the certificates of the PEM bundles as trusted certificate
+// entries of a JKS trust store, which all Java versions are able to read. The output
+// only depends on the certificates, so that unchanged trust stores are not updated.
+func newJavaTrustStore(bundles ...[]byte) ([]byte, error) {
+	certs := []*x509.Certificate{}
+	for _, bundle := range bundles {
+		for {
+			var block *pem.Block
+			block, bundle = pem.Decode(bundle)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				// Skip certificates the JVM is unlikely to accept either
+				continue
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in the CA bundle of the Insights proxy")
+	}
+
+	buf := &bytes.Buffer{}
+	writeInt := func(v any) {
+		// Writes to a bytes.Buffer cannot fail
+		_ = binary.Write(buf, binary.BigEndian, v)
+	}
+	writeUTF := func(s string) {
+		writeInt(uint16(len(s)))
+		buf.WriteString(s)
+	}
+	writeInt(uint32(jksMagic))
+	writeInt(uint32(jksVersion))
+	writeInt(uint32(len(certs)))
+	for idx, cert := range certs {
+		writeInt(uint32(jksTrustedCertTag))
+		writeUTF(fmt.Sprintf("cert-%d", idx))
+		writeInt(cert.NotBefore.UnixMilli())
+		writeUTF("X.509")
+		writeInt(uint32(len(cert.Raw)))
+		buf.Write(cert.Raw)
+	}
+	buf.Write(getJKSDigest(trustStorePassword, buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// getJKSDigest returns the integrity check appended to JKS trust stores, a SHA-1 digest
+// of the password's UTF-16 encoding, a fixed salt, and the trust store's contents
+func getJKSDigest(password string, contents []byte) []byte {
+	digest := sha1.New()
+	for _, char := range utf16.Encode([]rune(password)) {
+		digest.Write([]byte{byte(char >> 8), byte(char)})
+	}
+	digest.Write([]byte(jksIntegritySaltText))
+	digest.Write(contents)
+	return digest.Sum(nil)
+}
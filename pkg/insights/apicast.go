@@ -0,0 +1,50 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insights
+
+import (
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+)
+
+// APICastPolicy is an entry in the APICast policy chain. Its Configuration
+// must marshal to a JSON object, such as an APICastHeadersConfig.
+type APICastPolicy = controller.APICastPolicy
+
+// APICastPolicyExtension is an additional policy inserted into the policy chain
+// generated for the Insights proxy. Add these to InsightsIntegration.APICastPolicies.
+type APICastPolicyExtension = controller.APICastPolicyExtension
+
+// APICastPolicyPosition is a point in the generated policy chain where additional policies are inserted
+type APICastPolicyPosition = controller.APICastPolicyPosition
+
+// APICastHeadersConfig configures the APICast headers policy
+type APICastHeadersConfig = controller.APICastHeadersConfig
+
+// APICastHeaderOperation modifies a header of a request or response
+type APICastHeaderOperation = controller.APICastHeaderOperation
+
+// Points in the generated policy chain, which sets default credentials,
+// configures any upstream proxy, sets the Authorization and User-Agent
+// headers, then proxies the request
+const (
+	// Before all generated policies
+	APICastPolicyPositionFirst = controller.APICastPolicyPositionFirst
+	// Before the Authorization and User-Agent headers are set
+	APICastPolicyPositionBeforeHeaders = controller.APICastPolicyPositionBeforeHeaders
+	// After the Authorization and User-Agent headers are set, before the request is proxied
+	APICastPolicyPositionAfterHeaders = controller.APICastPolicyPositionAfterHeaders
+	// After all generated policies
+	APICastPolicyPositionLast = controller.APICastPolicyPositionLast
+)
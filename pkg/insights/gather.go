@@ -0,0 +1,61 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insights
+
+import (
+	"context"
+	"io"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Gather writes a gzip-compressed tar archive to w for inclusion in your operator's must-gather image.
+// It contains the objects managed by the Insights integration of your operator, their events, and the
+// logs of the proxy and your operator. Secrets are not collected, and tokens found in the collected data
+// are redacted. Pass the same WithInstanceName, WithSharedProxy and WithTokenSource options as to
+// NewInsightsIntegration. Items that cannot be collected are listed in errors.txt within the archive.
+func Gather(ctx context.Context, config *rest.Config, operatorName string, operatorNamespace string,
+	w io.Writer, opts ...Option) error {
+	i := NewInsightsIntegration(nil, operatorName, operatorNamespace, "", nil, opts...)
+	s := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, v1alpha1.AddToScheme} {
+		if err := addToScheme(s); err != nil {
+			return err
+		}
+	}
+	c, err := client.New(config, client.Options{Scheme: s})
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	return controller.Gather(ctx, &controller.GatherConfig{
+		Client:         c,
+		Pods:           clientset.CoreV1(),
+		Namespace:      operatorNamespace,
+		OperatorName:   operatorName,
+		Instance:       i.names.Instance,
+		ProxyNamespace: i.getProxyNamespace(),
+		TokenSource:    i.TokenSource,
+	}, w)
+}
@@ -0,0 +1,165 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insights
+
+import (
+	"time"
+
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+	runtimecontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+// Option configures an InsightsIntegration created by NewInsightsIntegration.
+// Options configuring the proxy take precedence over the corresponding
+// environment variables, while an InsightsProxy resource overrides both.
+type Option func(*InsightsIntegration)
+
+// OSUtils provides access to the environment variables the integration reads
+type OSUtils = common.OSUtils
+
+// ProxyPorts are the ports used by the Insights proxy. Zero values use the defaults.
+type ProxyPorts = controller.ProxyPorts
+
+// WithEnabled sets whether the Insights proxy is enabled, in place of INSIGHTS_ENABLED
+func WithEnabled(enabled bool) Option {
+	return func(i *InsightsIntegration) {
+		i.defaults.Enabled = &enabled
+	}
+}
+
+// WithBackendDomain sets the Red Hat Insights server host where reports are forwarded,
+// in place of INSIGHTS_BACKEND_DOMAIN
+func WithBackendDomain(domain string) Option {
+	return func(i *InsightsIntegration) {
+		i.defaults.BackendDomain = domain
+	}
+}
+
+// WithProxyImage sets the APICast image used on nodes of the provided architectures,
+// such as "arm64", in place of RELATED_IMAGE_INSIGHTS_PROXY and its variants.
+// If no architectures are provided, the image is used on amd64 nodes.
+func WithProxyImage(image string, architectures ...string) Option {
+	return func(i *InsightsIntegration) {
+		if len(architectures) == 0 {
+			architectures = []string{"amd64"}
+		}
+		if i.defaults.ProxyImages == nil {
+			i.defaults.ProxyImages = map[string]string{}
+		}
+		for _, arch := range architectures {
+			i.defaults.ProxyImages[arch] = image
+		}
+	}
+}
+
+// WithBuiltinProxyImage sets the image of the built-in proxy, normally your
+// operator's own image, in place of RELATED_IMAGE_INSIGHTS_BUILTIN_PROXY
+func WithBuiltinProxyImage(image string) Option {
+	return func(i *InsightsIntegration) {
+		i.defaults.BuiltinProxyImage = image
+	}
+}
+
+// WithProxyImplementation sets the proxy to deploy, in place of INSIGHTS_PROXY_IMPLEMENTATION
+func WithProxyImplementation(implementation v1alpha1.ProxyImplementation) Option {
+	return func(i *InsightsIntegration) {
+		i.defaults.Implementation = implementation
+	}
+}
+
+// WithUpstreamProxy sets the URL of an HTTP or HTTPS proxy used to reach the Insights backend,
+// in place of INSIGHTS_PROXY_URL and INSIGHTS_PROXY_DOMAIN
+func WithUpstreamProxy(proxyURL string) Option {
+	return func(i *InsightsIntegration) {
+		i.defaults.UpstreamProxy = proxyURL
+	}
+}
+
+// WithClusterDomain sets the DNS domain of the cluster, used to form the proxy's
+// fully qualified domain name. Defaults to cluster.local.
+func WithClusterDomain(domain string) Option {
+	return func(i *InsightsIntegration) {
+		i.clusterDomain = domain
+	}
+}
+
+// WithControllerOptions sets options for the Insights controller,
+// such as its maximum number of concurrent reconciles
+func WithControllerOptions(options runtimecontroller.Options) Option {
+	return func(i *InsightsIntegration) {
+		i.controllerOptions = options
+	}
+}
+
+// WithPorts sets the ports used by the Insights proxy. APICast always
+// serves its health probes on port 8090 and its metrics on port 9421.
+func WithPorts(ports ProxyPorts) Option {
+	return func(i *InsightsIntegration) {
+		i.ports = ports
+	}
+}
+
+// WithConnectivityCheckInterval sets how often the controller sends a request through the Insights proxy
+// to verify that Red Hat Insights accepts its credentials, reported by the BackendConnected condition.
+// Defaults to 10 minutes. A negative interval disables these checks.
+func WithConnectivityCheckInterval(interval time.Duration) Option {
+	return func(i *InsightsIntegration) {
+		i.connectivityCheckInterval = interval
+	}
+}
+
+// WithInstanceName prefixes the names of the objects managed by the integration, including
+// its InsightsProxy, config map and proxy Service, so that several operators may each manage
+// their own proxy within a namespace. The name must be a valid DNS label.
+func WithInstanceName(name string) Option {
+	return func(i *InsightsIntegration) {
+		i.names = common.NewResourceNames(name)
+	}
+}
+
+// WithSharedProxy shares the Insights proxy with other operators configured with the same namespace,
+// in place of INSIGHTS_SHARED_PROXY_NAMESPACE. One of these operators manages the proxy in that namespace
+// on behalf of all of them, and another takes over if it is uninstalled.
+func WithSharedProxy(namespace string) Option {
+	return func(i *InsightsIntegration) {
+		i.sharedNamespace = namespace
+	}
+}
+
+// WithOSUtils sets how environment variables are read
+func WithOSUtils(osUtils OSUtils) Option {
+	return func(i *InsightsIntegration) {
+		i.OSUtils = osUtils
+	}
+}
+
+// WithTokenSource sets the source of the token used to authenticate with Red Hat Insights,
+// see InsightsIntegration.TokenSource
+func WithTokenSource(source TokenSource) Option {
+	return func(i *InsightsIntegration) {
+		i.TokenSource = source
+	}
+}
+
+// WithAPICastPolicies sets policies inserted into the APICast policy chain, and the names of
+// any custom policies provided by your APICast image, see InsightsIntegration.APICastPolicies
+func WithAPICastPolicies(policies []APICastPolicyExtension, customPolicies ...string) Option {
+	return func(i *InsightsIntegration) {
+		i.APICastPolicies = policies
+		i.CustomAPICastPolicies = customPolicies
+	}
+}
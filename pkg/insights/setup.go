@@ -16,31 +16,71 @@ package insights
 
 import (
 	"context"
-	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/RedHatInsights/runtimes-inventory-operator/api/v1alpha1"
 	"github.com/RedHatInsights/runtimes-inventory-operator/internal/common"
 	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/webhook"
 	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimecontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// CABundleConfigMapName is the name of the config map in the operator's namespace
+	// containing the CA bundle used to verify the Insights proxy. Integrations configured
+	// using WithInstanceName should use GetCABundleConfigMapName instead.
+	CABundleConfigMapName = common.ProxyCABundleConfigMapName
+	// CABundleKey is the key within the CA bundle config map holding the PEM-encoded certificates
+	CABundleKey = common.ProxyCABundleKey
+	// JavaInjectorPath is the path of the webhook registered by SetupWebhook
+	JavaInjectorPath = webhook.JavaInjectorPath
+	// InjectJavaLabel is the label selecting pods or namespaces for the webhook registered by SetupWebhook
+	InjectJavaLabel = common.InjectJavaLabel
 )
 
 // InsightsIntegration allows your operator to manage a proxy
 // for sending Red Hat Insights reports from Java-based workloads
 // to the Runtimes Inventory service.
 type InsightsIntegration struct {
-	Manager         ctrl.Manager
-	Log             *logr.Logger
-	opName          string
-	opNamespace     string
-	userAgentPrefix string
+	Manager ctrl.Manager
+	Log     *logr.Logger
+	// Optional source of the token used to authenticate with Red Hat Insights,
+	// which must be set before calling Setup. By default, the Secret named by the
+	// INSIGHTS_TOKEN_SECRET environment variable is used, or the global pull secret
+	// on OpenShift. A token Secret named by an InsightsProxy takes precedence.
+	TokenSource TokenSource
+	// Optional policies inserted into the APICast policy chain of the proxy, which must
+	// be set before calling Setup. Policies must be bundled with APICast, or named in
+	// CustomAPICastPolicies. These are not used by the built-in proxy.
+	APICastPolicies []APICastPolicyExtension
+	// Optional names of custom policies provided by your APICast image
+	CustomAPICastPolicies []string
+
+	opName                    string
+	opNamespace               string
+	userAgentPrefix           string
+	defaults                  controller.ProxyDefaults
+	clusterDomain             string
+	ports                     ProxyPorts
+	controllerOptions         runtimecontroller.Options
+	names                     *common.ResourceNames
+	sharedNamespace           string
+	connectivityCheckInterval time.Duration
 	common.OSUtils
 }
 
@@ -49,20 +89,37 @@ type InsightsIntegration struct {
 // Provide the operator's name and namespace,
 // which can be discovered using the Kubernetes downward API.
 // The User Agent prefix must be an approved UHC Auth Proxy prefix.
-func NewInsightsIntegration(mgr ctrl.Manager, operatorName string, operatorNamespace string, userAgentPrefix string, log *logr.Logger) *InsightsIntegration {
-	return &InsightsIntegration{
+// Options may configure the integration in place of environment variables.
+func NewInsightsIntegration(mgr ctrl.Manager, operatorName string, operatorNamespace string, userAgentPrefix string,
+	log *logr.Logger, opts ...Option) *InsightsIntegration {
+	integration := &InsightsIntegration{
 		Manager:         mgr,
 		Log:             log,
 		opName:          operatorName,
 		opNamespace:     operatorNamespace,
 		userAgentPrefix: userAgentPrefix,
+		names:           common.NewResourceNames(""),
 		OSUtils:         &common.DefaultOSUtils{},
 	}
+	for _, opt := range opts {
+		opt(integration)
+	}
+	if len(integration.sharedNamespace) == 0 {
+		integration.sharedNamespace = integration.GetEnv(common.EnvInsightsSharedProxyNamespace)
+	}
+	return integration
 }
 
 // Setup adds a controller to your manager, which creates and
-// manages the HTTP proxy container that workloads may use
-// to send reports to Red Hat Insights.
+// manages the HTTPS proxy container that workloads may use
+// to send reports to Red Hat Insights. Whether the proxy is
+// enabled is determined by WithEnabled or the INSIGHTS_ENABLED environment
+// variable, unless overridden by an InsightsProxy resource in the operator's namespace.
+// Outside of OpenShift, a Secret containing a Red Hat token must be
+// configured using the INSIGHTS_TOKEN_SECRET environment variable
+// or an InsightsProxy.
+// Workloads should verify the proxy's certificate using the CA bundle
+// from GetCABundle.
 func (i *InsightsIntegration) Setup() (*url.URL, error) {
 	var proxyUrl *url.URL
 	// This will happen when running the operator locally
@@ -78,22 +135,66 @@ func (i *InsightsIntegration) Setup() (*url.URL, error) {
 		i.Log.Info("User Agent prefix not detected")
 		return nil, nil
 	}
+	err := i.names.Validate()
+	if err != nil {
+		return nil, err
+	}
 
-	ctx := context.Background()
-	if i.isInsightsEnabled() {
-		err := i.createInsightsController()
+	// Register the InsightsProxy API with the manager's scheme
+	err = v1alpha1.AddToScheme(i.Manager.GetScheme())
+	if err != nil {
+		return nil, err
+	}
+	// Pod security settings and the APIs used depend on the platform
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(i.Manager.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	platform, err := common.DetectPlatform(discoveryClient)
+	if err != nil {
+		i.Log.Error(err, "failed to detect platform")
+		return nil, err
+	}
+	i.Log.Info("Detected platform", "openshift", platform.OpenShift, "scc", platform.SCC,
+		"version", platform.Version.String())
+	// The OpenShift config API is only used when the cluster provides it
+	if platform.OpenShift {
+		err = configv1.AddToScheme(i.Manager.GetScheme())
 		if err != nil {
-			i.Log.Error(err, "unable to add controller to manager", "controller", "Insights")
 			return nil, err
 		}
-		// Create a Config Map to be used as a parent of all Insights Proxy related objects
-		err = i.createConfigMap(ctx)
-		if err != nil {
-			i.Log.Error(err, "failed to create config map for Insights")
-			return nil, err
+	} else if i.TokenSource == nil {
+		i.Log.Info("OpenShift config API not found, a Secret containing a Red Hat token must be configured")
+	}
+
+	ctx := context.Background()
+	enabled, err := i.isInsightsEnabled(ctx)
+	if err != nil {
+		i.Log.Error(err, "failed to determine whether Insights is enabled")
+		return nil, err
+	}
+
+	// The controller is always added, so that Insights may be
+	// enabled or disabled later using an InsightsProxy
+	err = i.createInsightsController(platform)
+	if err != nil {
+		i.Log.Error(err, "unable to add controller to manager", "controller", "Insights")
+		return nil, err
+	}
+
+	if enabled {
+		// A shared proxy's config map is created by the operator managing it,
+		// without an owner so that it outlives that operator
+		if len(i.sharedNamespace) == 0 {
+			// Create a Config Map to be used as a parent of all Insights Proxy related objects
+			err = i.createConfigMap(ctx)
+			if err != nil {
+				i.Log.Error(err, "failed to create config map for Insights")
+				return nil, err
+			}
 		}
 		proxyUrl = i.getProxyURL()
-	} else {
+	} else if len(i.sharedNamespace) == 0 {
 		// Delete any previously created Config Map (and its children)
 		err := i.deleteConfigMap(ctx)
 		if err != nil {
@@ -105,18 +206,98 @@ func (i *InsightsIntegration) Setup() (*url.URL, error) {
 	return proxyUrl, nil
 }
 
-func (i *InsightsIntegration) isInsightsEnabled() bool {
-	return strings.ToLower(i.GetEnv(common.EnvInsightsEnabled)) == "true"
+// SetupWebhook registers a mutating admission webhook with your manager's webhook server,
+// if enabled by the INSIGHTS_WEBHOOK_ENABLED environment variable. The webhook configures
+// the Insights Java client of new pods to report through the Insights proxy, for pods or
+// namespaces labelled with runtimes-inventory.redhat.com/inject-java=true. If an image containing the
+// Insights Java agent is provided by the RELATED_IMAGE_INSIGHTS_JAVA_AGENT environment variable,
+// the agent is also added to these pods. Your operator must deploy a MutatingWebhookConfiguration
+// directing pod creation to the JavaInjectorPath of its webhook server.
+func (i *InsightsIntegration) SetupWebhook() error {
+	if strings.ToLower(i.GetEnv(common.EnvInsightsWebhookEnabled)) != "true" {
+		return nil
+	}
+	if len(i.opNamespace) == 0 {
+		i.Log.Info("Operator namespace not detected")
+		return nil
+	}
+	injector := webhook.NewJavaInjector(&webhook.JavaInjectorConfig{
+		Client:     i.Manager.GetClient(),
+		APIReader:  i.Manager.GetAPIReader(),
+		Writer:     i.Manager.GetClient(),
+		Scheme:     i.Manager.GetScheme(),
+		Log:        ctrl.Log.WithName("webhooks").WithName("InsightsJava"),
+		Namespace:  i.getProxyNamespace(),
+		Instance:   i.names.Instance,
+		ProxyURL:   i.getProxyURL(),
+		AgentImage: i.GetEnv(common.EnvInsightsJavaAgentImageTag),
+		AgentPath:  i.GetEnv(common.EnvInsightsJavaAgentPath),
+	})
+	i.Manager.GetWebhookServer().Register(JavaInjectorPath, &admission.Webhook{Handler: injector})
+	i.Log.Info("Insights Java webhook registered", "path", JavaInjectorPath)
+	return nil
 }
 
-func (i *InsightsIntegration) createInsightsController() error {
+func (i *InsightsIntegration) isInsightsEnabled(ctx context.Context) (bool, error) {
+	// An InsightsProxy takes precedence over the environment
+	proxy := &v1alpha1.InsightsProxy{}
+	// Use the APIReader instead of the cache, since the cache may not be synced yet
+	err := i.Manager.GetAPIReader().Get(ctx, types.NamespacedName{
+		Name: i.names.InsightsProxy, Namespace: i.getProxyNamespace()}, proxy)
+	if err == nil && proxy.Spec.Enabled != nil {
+		return *proxy.Spec.Enabled, nil
+	} else if err != nil && !kerrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return false, err
+	}
+	return i.defaults.IsEnabled(i.OSUtils), nil
+}
+
+// getProxyNamespace returns the namespace of the proxy and the objects configuring it,
+// which is the operator's own namespace unless the proxy is shared
+func (i *InsightsIntegration) getProxyNamespace() string {
+	if len(i.sharedNamespace) > 0 {
+		return i.sharedNamespace
+	}
+	return i.opNamespace
+}
+
+func (i *InsightsIntegration) getProxyURL() *url.URL {
+	clusterDomain := i.clusterDomain
+	if len(clusterDomain) == 0 {
+		clusterDomain = common.DefaultClusterDomain
+	}
+	proxyURL := controller.GetProxyURL(i.names.Service, i.getProxyNamespace(), clusterDomain, i.ports.WithDefaults().HTTPS)
+	if len(i.sharedNamespace) > 0 {
+		// Requests beneath this path are reported with this operator's User-Agent prefix
+		proxyURL.Path = controller.GetSharedProxyMemberPath(i.opNamespace, i.opName)
+	}
+	return proxyURL
+}
+
+func (i *InsightsIntegration) createInsightsController(platform *common.Platform) error {
 	config := &controller.InsightsReconcilerConfig{
-		Client:          i.Manager.GetClient(),
-		Log:             ctrl.Log.WithName("controllers").WithName("Insights"),
-		Scheme:          i.Manager.GetScheme(),
-		Namespace:       i.opNamespace,
-		UserAgentPrefix: i.userAgentPrefix,
-		OSUtils:         i.OSUtils,
+		Client:                    i.Manager.GetClient(),
+		Log:                       ctrl.Log.WithName("controllers").WithName("Insights"),
+		Scheme:                    i.Manager.GetScheme(),
+		Recorder:                  i.Manager.GetEventRecorderFor(controller.EventRecorderName),
+		Namespace:                 i.getProxyNamespace(),
+		UserAgentPrefix:           i.userAgentPrefix,
+		OperatorName:              i.opName,
+		APIReader:                 i.Manager.GetAPIReader(),
+		Platform:                  platform,
+		TokenSource:               i.TokenSource,
+		APICastPolicies:           i.APICastPolicies,
+		CustomAPICastPolicies:     i.CustomAPICastPolicies,
+		Defaults:                  i.defaults,
+		ClusterDomain:             i.clusterDomain,
+		Ports:                     i.ports,
+		ControllerOptions:         i.controllerOptions,
+		Instance:                  i.names.Instance,
+		OSUtils:                   i.OSUtils,
+		ConnectivityCheckInterval: i.connectivityCheckInterval,
+	}
+	if len(i.sharedNamespace) > 0 {
+		config.Shared = &controller.SharedProxyConfig{OperatorNamespace: i.opNamespace}
 	}
 	controller, err := controller.NewInsightsReconciler(config)
 	if err != nil {
@@ -140,7 +321,7 @@ func (i *InsightsIntegration) createConfigMap(ctx context.Context) error {
 
 	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      common.InsightsConfigMapName,
+			Name:      i.names.ConfigMap,
 			Namespace: i.opNamespace,
 		},
 	}
@@ -159,25 +340,61 @@ func (i *InsightsIntegration) createConfigMap(ctx context.Context) error {
 
 func (i *InsightsIntegration) deleteConfigMap(ctx context.Context) error {
 	// Children will be garbage collected
-	cm := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      common.InsightsConfigMapName,
-			Namespace: i.opNamespace,
-		},
+	cm := &corev1.ConfigMap{}
+	// Use the APIReader instead of the cache, since the cache may not be synced yet
+	err := i.Manager.GetAPIReader().Get(ctx, types.NamespacedName{
+		Name: i.names.ConfigMap, Namespace: i.opNamespace}, cm)
+	if err == nil {
+		err = i.Manager.GetClient().Delete(ctx, cm, &client.DeleteOptions{})
 	}
-
-	err := i.Manager.GetClient().Delete(ctx, cm, &client.DeleteOptions{})
 	if err == nil {
 		i.Log.Info("Config Map for Insights deleted", "name", cm.Name, "namespace", cm.Namespace)
+		i.Manager.GetEventRecorderFor(controller.EventRecorderName).Event(cm, corev1.EventTypeNormal,
+			controller.EventReasonProxyDeleted, "Deleted the Insights proxy because Insights is disabled")
 	}
 	// This may not exist if no config map was previously created
 	return client.IgnoreNotFound(err)
 }
 
-func (i *InsightsIntegration) getProxyURL() *url.URL {
-	return &url.URL{
-		Scheme: "http", // TODO add https support
-		Host: fmt.Sprintf("%s.%s.svc.cluster.local:%d", common.ProxyServiceName, i.opNamespace,
-			common.ProxyServicePort),
+// GetConditions returns the status conditions reported by the Insights controller
+// for the proxy, such as whether it is ready and whether a token could be obtained.
+// See the ConditionType constants in the v1alpha1 API package for the possible types.
+// No conditions are returned if Insights is disabled.
+func (i *InsightsIntegration) GetConditions(ctx context.Context) ([]metav1.Condition, error) {
+	cm := &corev1.ConfigMap{}
+	err := i.Manager.GetClient().Get(ctx, types.NamespacedName{Name: i.names.ConfigMap,
+		Namespace: i.getProxyNamespace()}, cm)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return []metav1.Condition{}, nil
+		}
+		return nil, err
+	}
+	return controller.GetConfigMapConditions(cm)
+}
+
+// GetCABundleConfigMapName returns the name of the config map in the operator's namespace
+// containing the CA bundle used to verify the Insights proxy, under the CABundleKey key
+func (i *InsightsIntegration) GetCABundleConfigMapName() string {
+	return i.names.CABundleConfigMap
+}
+
+// GetCABundle returns the PEM-encoded CA certificates that workloads should trust
+// when connecting to the proxy URL returned by Setup. On OpenShift, the bundle is
+// injected by the service CA operator. Returns nil if the bundle is not yet available.
+func (i *InsightsIntegration) GetCABundle(ctx context.Context) ([]byte, error) {
+	cm := &corev1.ConfigMap{}
+	err := i.Manager.GetClient().Get(ctx, types.NamespacedName{Name: i.names.CABundleConfigMap,
+		Namespace: i.getProxyNamespace()}, cm)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	bundle, pres := cm.Data[common.ProxyCABundleKey]
+	if !pres || len(bundle) == 0 {
+		return nil, nil
 	}
+	return []byte(bundle), nil
 }
@@ -15,8 +15,12 @@
 package insights_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller/test"
@@ -40,6 +44,8 @@ type setupTestInput struct {
 	objs        []ctrlclient.Object
 	opNamespace string
 	integration *insights.InsightsIntegration
+	options     []insights.Option
+	manager     *test.FakeManager
 	*test.TestUtilsConfig
 	*test.InsightsTestResources
 }
@@ -83,9 +89,11 @@ var _ = Describe("InsightsIntegration", func() {
 				Expect(err).ToNot(HaveOccurred())
 			}
 
-			manager := test.NewFakeManager(t.client, s, &logger)
+			t.manager = test.NewFakeManager(t.client, s, &logger)
+			t.manager.Config = cfg
 			deploy := t.NewOperatorDeployment()
-			t.integration = insights.NewInsightsIntegration(manager, deploy.Name, t.opNamespace, t.UserAgentPrefix, &logger)
+			t.integration = insights.NewInsightsIntegration(t.manager, deploy.Name, t.opNamespace, t.UserAgentPrefix, &logger,
+				t.options...)
 			t.integration.OSUtils = test.NewTestOSUtils(t.TestUtilsConfig)
 		})
 
@@ -105,7 +113,7 @@ var _ = Describe("InsightsIntegration", func() {
 				result, err := t.integration.Setup()
 				Expect(err).ToNot(HaveOccurred())
 				Expect(result).ToNot(BeNil())
-				Expect(result.String()).To(Equal(fmt.Sprintf("http://insights-proxy.%s.svc.cluster.local:8080", t.Namespace)))
+				Expect(result.String()).To(Equal(fmt.Sprintf("https://insights-proxy.%s.svc.cluster.local:8443", t.Namespace)))
 			})
 
 			It("should create config map", func() {
@@ -125,6 +133,64 @@ var _ = Describe("InsightsIntegration", func() {
 				Expect(metav1.IsControlledBy(actual, t.getOperatorDeployment())).To(BeTrue())
 				Expect(actual.Data).To(BeEmpty())
 			})
+
+			It("should return no conditions before reconciling", func() {
+				_, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+
+				conditions, err := t.integration.GetConditions(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conditions).To(BeEmpty())
+			})
+		})
+
+		Context("with conditions reported on the config map", func() {
+			BeforeEach(func() {
+				cm := t.NewProxyConfigMap()
+				cm.Data = map[string]string{
+					"conditions": `[{"type":"Ready","status":"False","reason":"TokenMissing",` +
+						`"message":"no token","lastTransitionTime":"2024-01-01T00:00:00Z"}]`,
+				}
+				t.objs = append(t.objs, cm)
+			})
+
+			It("should return the conditions", func() {
+				conditions, err := t.integration.GetConditions(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conditions).To(HaveLen(1))
+				Expect(conditions[0].Type).To(Equal("Ready"))
+				Expect(conditions[0].Status).To(Equal(metav1.ConditionFalse))
+				Expect(conditions[0].Reason).To(Equal("TokenMissing"))
+				Expect(conditions[0].Message).To(Equal("no token"))
+			})
+		})
+
+		Context("with a CA bundle", func() {
+			BeforeEach(func() {
+				t.objs = append(t.objs, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      insights.CABundleConfigMapName,
+						Namespace: t.Namespace,
+					},
+					Data: map[string]string{
+						insights.CABundleKey: "-----BEGIN CERTIFICATE-----",
+					},
+				})
+			})
+
+			It("should return the CA bundle", func() {
+				bundle, err := t.integration.GetCABundle(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(bundle)).To(Equal("-----BEGIN CERTIFICATE-----"))
+			})
+		})
+
+		Context("without a CA bundle", func() {
+			It("should return nil", func() {
+				bundle, err := t.integration.GetCABundle(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(bundle).To(BeNil())
+			})
 		})
 
 		Context("with Insights disabled", func() {
@@ -154,6 +220,153 @@ var _ = Describe("InsightsIntegration", func() {
 				Expect(err).To(HaveOccurred())
 				Expect(kerrors.IsNotFound(err)).To(BeTrue(), err.Error())
 			})
+
+			It("should emit an event for the deleted proxy", func() {
+				_, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(t.manager.Recorder.Events).To(Receive(Equal(
+					"Normal ProxyDeleted Deleted the Insights proxy because Insights is disabled")))
+			})
+		})
+
+		Context("with Insights enabled by an InsightsProxy", func() {
+			BeforeEach(func() {
+				t.EnvInsightsEnabled = &[]bool{false}[0]
+				proxy := t.NewInsightsProxy()
+				proxy.Spec.Enabled = &[]bool{true}[0]
+				t.objs = append(t.objs, proxy)
+			})
+
+			It("should return proxy URL", func() {
+				result, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).ToNot(BeNil())
+				Expect(result.String()).To(Equal(fmt.Sprintf("https://insights-proxy.%s.svc.cluster.local:8443", t.Namespace)))
+			})
+
+			It("should create config map", func() {
+				_, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+
+				expected := t.NewProxyConfigMap()
+				actual := &corev1.ConfigMap{}
+				err = t.client.Get(context.Background(), types.NamespacedName{
+					Name:      expected.Name,
+					Namespace: expected.Namespace,
+				}, actual)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(metav1.IsControlledBy(actual, t.getOperatorDeployment())).To(BeTrue())
+			})
+		})
+
+		Context("with Insights enabled by an option", func() {
+			BeforeEach(func() {
+				t.EnvInsightsEnabled = &[]bool{false}[0]
+				t.options = []insights.Option{insights.WithEnabled(true)}
+			})
+
+			It("should return proxy URL", func() {
+				result, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).ToNot(BeNil())
+				Expect(result.String()).To(Equal(fmt.Sprintf("https://insights-proxy.%s.svc.cluster.local:8443", t.Namespace)))
+			})
+		})
+
+		Context("with a cluster domain and ports", func() {
+			BeforeEach(func() {
+				t.options = []insights.Option{
+					insights.WithClusterDomain("example.internal"),
+					insights.WithPorts(insights.ProxyPorts{HTTPS: 9443}),
+				}
+			})
+
+			It("should return proxy URL", func() {
+				result, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).ToNot(BeNil())
+				Expect(result.String()).To(Equal(fmt.Sprintf("https://insights-proxy.%s.svc.example.internal:9443", t.Namespace)))
+			})
+		})
+
+		Context("with an instance name", func() {
+			BeforeEach(func() {
+				t.options = []insights.Option{insights.WithInstanceName("other")}
+			})
+
+			It("should return proxy URL", func() {
+				result, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).ToNot(BeNil())
+				Expect(result.String()).To(Equal(fmt.Sprintf("https://other-insights-proxy.%s.svc.cluster.local:8443", t.Namespace)))
+			})
+
+			It("should create config map", func() {
+				_, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+
+				actual := &corev1.ConfigMap{}
+				err = t.client.Get(context.Background(), types.NamespacedName{
+					Name:      "other-insights-proxy",
+					Namespace: t.Namespace,
+				}, actual)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(metav1.IsControlledBy(actual, t.getOperatorDeployment())).To(BeTrue())
+			})
+
+			It("should name the CA bundle config map", func() {
+				Expect(t.integration.GetCABundleConfigMapName()).To(Equal("other-insights-proxy-ca"))
+			})
+		})
+
+		Context("with an invalid instance name", func() {
+			BeforeEach(func() {
+				t.options = []insights.Option{insights.WithInstanceName("Not_Valid")}
+			})
+
+			It("should fail", func() {
+				_, err := t.integration.Setup()
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("with a shared proxy", func() {
+			BeforeEach(func() {
+				t.options = []insights.Option{insights.WithSharedProxy("insights-shared")}
+			})
+
+			It("should return the shared proxy's URL", func() {
+				result, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).ToNot(BeNil())
+				Expect(result.String()).To(Equal("https://insights-proxy.insights-shared.svc.cluster.local:8443/members/" +
+					t.opNamespace + "/" + t.NewOperatorDeployment().Name))
+			})
+
+			It("should not create config map", func() {
+				_, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+
+				err = t.client.Get(context.Background(), types.NamespacedName{
+					Name:      t.NewProxyConfigMap().Name,
+					Namespace: t.Namespace,
+				}, &corev1.ConfigMap{})
+				Expect(kerrors.IsNotFound(err)).To(BeTrue())
+			})
+		})
+
+		Context("with invalid ports", func() {
+			BeforeEach(func() {
+				t.options = []insights.Option{
+					insights.WithPorts(insights.ProxyPorts{HTTPS: 8090}),
+				}
+			})
+
+			It("should fail", func() {
+				_, err := t.integration.Setup()
+				Expect(err).To(HaveOccurred())
+			})
 		})
 
 		Context("when run out-of-cluster", func() {
@@ -181,6 +394,34 @@ var _ = Describe("InsightsIntegration", func() {
 				Expect(kerrors.IsNotFound(err)).To(BeTrue(), err.Error())
 			})
 		})
+
+		Context("gathering a support bundle", func() {
+			It("should collect the config map and operator", func() {
+				_, err := t.integration.Setup()
+				Expect(err).ToNot(HaveOccurred())
+
+				buf := &bytes.Buffer{}
+				err = insights.Gather(context.Background(), cfg, t.NewOperatorDeployment().Name, t.opNamespace, buf)
+				Expect(err).ToNot(HaveOccurred())
+
+				gz, err := gzip.NewReader(buf)
+				Expect(err).ToNot(HaveOccurred())
+				tr := tar.NewReader(gz)
+				var names []string
+				for {
+					header, err := tr.Next()
+					if err == io.EOF {
+						break
+					}
+					Expect(err).ToNot(HaveOccurred())
+					names = append(names, header.Name)
+				}
+				Expect(names).To(ContainElements(
+					fmt.Sprintf("insights/%s/configmap/insights-proxy.yaml", t.Namespace),
+					fmt.Sprintf("insights/%s/deployment/%s.yaml", t.Namespace, t.NewOperatorDeployment().Name),
+				))
+			})
+		})
 	})
 })
 
@@ -0,0 +1,63 @@
+// Copyright The Cryostat Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insights
+
+import (
+	"github.com/RedHatInsights/runtimes-inventory-operator/internal/controller"
+)
+
+// TokenSource provides the token the Insights proxy uses to authenticate
+// with Red Hat Insights. Set InsightsIntegration.TokenSource to use one of the
+// built-in sources below, or your own implementation.
+type TokenSource = controller.TokenSource
+
+// SecretTokenSource is a TokenSource that reads its token from a Secret.
+// The proxy is updated whenever the Secret changes, while other sources
+// are read again periodically.
+type SecretTokenSource = controller.SecretTokenSource
+
+// NewPullSecretTokenSource returns a TokenSource reading the cloud.openshift.com
+// credential from a pull secret, such as the one provided for a hosted control plane.
+// An empty namespace or name defaults to the OpenShift global pull secret.
+// The operator must be granted permission to get, list and watch this Secret.
+func NewPullSecretTokenSource(namespace string, name string) TokenSource {
+	return controller.NewPullSecretTokenSource(namespace, name)
+}
+
+// NewSecretKeyTokenSource returns a TokenSource reading the token from a key
+// of a Secret. An empty key defaults to "token".
+func NewSecretKeyTokenSource(namespace string, name string, key string) TokenSource {
+	return controller.NewSecretKeyTokenSource(namespace, name, key)
+}
+
+// NewFileTokenSource returns a TokenSource reading the token from a file,
+// such as a Secret mounted into the operator's pod
+func NewFileTokenSource(path string) TokenSource {
+	return controller.NewFileTokenSource(path)
+}
+
+// NewSSOTokenSource returns a TokenSource exchanging credentials in a Secret for short-lived
+// access tokens from Red Hat SSO. The Secret contains either a Red Hat offline token under its
+// "offline_token" key, or the "client_id" and "client_secret" of a console.redhat.com service
+// account. An empty token URL defaults to the production Red Hat SSO token endpoint.
+func NewSSOTokenSource(namespace string, name string, tokenURL string) TokenSource {
+	return controller.NewSSOTokenSource(namespace, name, tokenURL)
+}
+
+// NewTokenError returns an error for a TokenSource to report with the provided reason,
+// one of the Reason constants in the v1alpha1 API package
+func NewTokenError(reason string, err error) error {
+	return controller.NewTokenError(reason, err)
+}